@@ -10,6 +10,7 @@ import (
 
 	"github.com/AkihiroSuda/lima/pkg/guestagent"
 	"github.com/AkihiroSuda/lima/pkg/guestagent/api/server"
+	"github.com/AkihiroSuda/lima/pkg/guestagent/ticker"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -32,9 +33,14 @@ var daemonCommand = &cli.Command{
 		},
 		&cli.DurationFlag{
 			Name:  "tick",
-			Usage: "tick for polling events",
+			Usage: "tick for polling events (when eBPF is not available)",
 			Value: 3 * time.Second,
 		},
+		&cli.DurationFlag{
+			Name:  "tick-ebpf",
+			Usage: "tick for polling events when eBPF is available (for detecting closed UDP sockets)",
+			Value: 30 * time.Second,
+		},
 	},
 	Action: daemonAction,
 }
@@ -48,17 +54,23 @@ func daemonAction(clicontext *cli.Context) error {
 	if tick == 0 {
 		return errors.New("tick must be specified")
 	}
+	tickEBPF := clicontext.Duration("tick-ebpf")
+	if tickEBPF == 0 {
+		return errors.New("tick-ebpf must be specified")
+	}
 	if os.Geteuid() == 0 {
 		return errors.New("must not run as the root")
 	}
-	logrus.Infof("event tick: %v", tick)
 
 	newTicker := func() (<-chan time.Time, func()) {
-		// TODO: use an equivalent of `bpftrace -e 'tracepoint:syscalls:sys_*_bind { printf("tick\n"); }')`,
-		// without depending on `bpftrace` binary.
-		// The agent binary will need CAP_BPF file cap.
-		ticker := time.NewTicker(tick)
-		return ticker.C, ticker.Stop
+		ebpfTick, ebpfStop, err := ticker.NewEBPFTicker()
+		if err != nil {
+			logrus.WithError(err).Infof("eBPF is not available, falling back to polling events with tick %v", tick)
+			return ticker.NewSimpleTicker(tick)
+		}
+		logrus.Infof("watching events with eBPF, along with polling events with tick %v", tickEBPF)
+		simpleTick, simpleStop := ticker.NewSimpleTicker(tickEBPF)
+		return ticker.NewCompoundTicker(ebpfTick, ebpfStop, simpleTick, simpleStop)
 	}
 
 	agent := guestagent.New(newTicker)
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/cidata"
+	"github.com/AkihiroSuda/lima/pkg/downloader"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var cacheCommand = &cli.Command{
+	Name:  "cache",
+	Usage: "Manage the download cache",
+	Subcommands: []*cli.Command{
+		cacheListCommand,
+		cachePruneCommand,
+		cacheRemoveCommand,
+	},
+}
+
+var cacheListCommand = &cli.Command{
+	Name:    "list",
+	Aliases: []string{"ls"},
+	Usage:   "List the cached files",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "JSONify output",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "Only show URLs",
+		},
+	},
+	Action: cacheListAction,
+}
+
+func cacheListAction(clicontext *cli.Context) error {
+	if clicontext.NArg() > 0 {
+		return errors.New("too many arguments")
+	}
+	if clicontext.Bool("quiet") && clicontext.Bool("json") {
+		return errors.New("option --quiet conflicts with --json")
+	}
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	if clicontext.Bool("quiet") {
+		for _, e := range entries {
+			fmt.Fprintln(clicontext.App.Writer, cacheEntryName(e))
+		}
+		return nil
+	}
+	if clicontext.Bool("json") {
+		for _, e := range entries {
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(clicontext.App.Writer, string(b))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "URL\tSIZE\tLAST USED")
+	for _, e := range entries {
+		size := units.BytesSize(float64(e.Size))
+		if !e.Complete {
+			size += " (incomplete)"
+		}
+		lastUsed := "-"
+		if !e.LastUsed.IsZero() {
+			lastUsed = units.HumanDuration(time.Since(e.LastUsed)) + " ago"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", cacheEntryName(e), size, lastUsed)
+	}
+	return w.Flush()
+}
+
+var cachePruneCommand = &cli.Command{
+	Name:  "prune",
+	Usage: "Remove the cached files that are not used by any instance",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "remove all the cached files, including the ones used by the instances",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only show the files to be removed",
+		},
+	},
+	Action: cachePruneAction,
+}
+
+func cachePruneAction(clicontext *cli.Context) error {
+	if clicontext.NArg() > 0 {
+		return errors.New("too many arguments")
+	}
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	if !clicontext.Bool("all") {
+		used, err = usedURLs()
+		if err != nil {
+			return err
+		}
+	}
+	var total int64
+	for _, e := range entries {
+		if used[e.URL] {
+			logrus.Debugf("Keeping %q (used by an instance)", e.URL)
+			continue
+		}
+		if clicontext.Bool("dry-run") {
+			fmt.Fprintln(clicontext.App.Writer, cacheEntryName(e))
+		} else {
+			logrus.Infof("Removing %q", cacheEntryName(e))
+			if err := downloader.RemoveCacheEntry(e); err != nil {
+				return err
+			}
+		}
+		total += e.Size
+	}
+	if !clicontext.Bool("dry-run") {
+		logrus.Infof("Reclaimed %s", units.BytesSize(float64(total)))
+	}
+	return nil
+}
+
+var cacheRemoveCommand = &cli.Command{
+	Name:      "rm",
+	Aliases:   []string{"remove", "delete"},
+	Usage:     "Remove the cached files",
+	ArgsUsage: "URL|KEY [URL|KEY, ...]",
+	Action:    cacheRemoveAction,
+}
+
+func cacheRemoveAction(clicontext *cli.Context) error {
+	if clicontext.NArg() == 0 {
+		return errors.New("requires at least 1 argument")
+	}
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	for _, arg := range clicontext.Args().Slice() {
+		e, err := findCacheEntry(entries, arg)
+		if err != nil {
+			return err
+		}
+		if err := downloader.RemoveCacheEntry(*e); err != nil {
+			return err
+		}
+		logrus.Infof("Removed %q", cacheEntryName(*e))
+	}
+	return nil
+}
+
+func cacheEntries() ([]downloader.CacheEntry, error) {
+	cacheDir, err := downloader.DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return downloader.CacheEntries(cacheDir)
+}
+
+// findCacheEntry finds the entry by the URL, the key, or the unique prefix of the key.
+func findCacheEntry(entries []downloader.CacheEntry, s string) (*downloader.CacheEntry, error) {
+	var found []downloader.CacheEntry
+	for _, e := range entries {
+		if e.URL == s || e.Key == s {
+			return &e, nil
+		}
+		if strings.HasPrefix(e.Key, s) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.Errorf("no cache entry found for %q", s)
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, errors.Errorf("ambiguous key prefix %q", s)
+	}
+}
+
+func cacheEntryName(e downloader.CacheEntry) string {
+	if e.URL == "" {
+		return e.Key
+	}
+	return e.URL
+}
+
+// usedURLs returns the URLs used by the instances.
+// usedURLs fails when the lima.yaml of an instance cannot be loaded, so that the cache of the instance is not pruned by mistake.
+func usedURLs() (map[string]bool, error) {
+	used := make(map[string]bool)
+	instNames, err := store.Instances()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return used, nil
+		}
+		return nil, err
+	}
+	for _, instName := range instNames {
+		instDir, err := store.InstanceDir(instName)
+		if err != nil {
+			return nil, err
+		}
+		y, err := store.LoadYAMLByFilePath(filepath.Join(instDir, filenames.LimaYAML))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load the YAML of instance %q (try `limactl cache prune --all`)", instName)
+		}
+		for _, img := range y.Images {
+			used[img.Location] = true
+		}
+		if *y.Containerd.System || *y.Containerd.User {
+			archiveURL, err := cidata.NerdctlArchiveURL(y.Arch)
+			if err != nil {
+				return nil, err
+			}
+			used[archiveURL] = true
+		}
+	}
+	return used, nil
+}
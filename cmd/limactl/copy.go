@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/AkihiroSuda/lima/pkg/sshutil"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/containerd/containerd/identifiers"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var copyCommand = &cli.Command{
+	Name:      "copy",
+	Aliases:   []string{"cp"},
+	Usage:     "Copy files between host and guest",
+	ArgsUsage: "SOURCE ... TARGET",
+	Description: "Prefix guest filenames with the instance name and a colon.\n" +
+		"Example: limactl copy default:/etc/os-release .\n" +
+		"Files can be also copied between two instances: limactl copy foo:/tmp/file bar:/tmp/file",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r"},
+			Usage:   "copy directories recursively",
+		},
+	},
+	Action:       copyAction,
+	BashComplete: copyBashComplete,
+}
+
+func copyAction(clicontext *cli.Context) error {
+	if clicontext.NArg() < 2 {
+		return errors.Errorf("requires at least 2 arguments: SOURCE DEST")
+	}
+	arg0, err := exec.LookPath("scp")
+	if err != nil {
+		return err
+	}
+
+	// The instances are specified as host aliases in a temporary ssh_config,
+	// so that each of the instances can have its own port and SSH master (ControlPath).
+	instances := make(map[string]*store.Instance)
+	var scpPaths []string
+	for _, arg := range clicontext.Args().Slice() {
+		instName, path, ok := splitCopyPath(arg)
+		if !ok {
+			scpPaths = append(scpPaths, arg)
+			continue
+		}
+		if _, ok := instances[instName]; !ok {
+			inst, err := store.Inspect(instName)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return errors.Errorf("instance %q does not exist, run `limactl start %s` to create a new instance", instName, instName)
+				}
+				return err
+			}
+			if inst.Status != store.StatusRunning {
+				return errors.Errorf("instance %q is not running (status %q), run `limactl start %s` to start the instance", instName, inst.Status, instName)
+			}
+			instances[instName] = inst
+		}
+		scpPaths = append(scpPaths, copyHostAlias(instName)+":"+path)
+	}
+	if len(instances) == 0 {
+		return errors.New("at least one of SOURCE and DEST has to be INSTANCE:PATH")
+	}
+
+	sshConfig, err := writeCopySSHConfig(instances)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sshConfig)
+
+	args := []string{"-F", sshConfig, "-q"}
+	if clicontext.Bool("recursive") {
+		args = append(args, "-r")
+	}
+	if len(instances) > 1 {
+		// Copy between the instances via the host, as the instances cannot reach each other via SSH
+		args = append(args, "-3")
+	}
+	args = append(args, "--")
+	args = append(args, scpPaths...)
+	cmd := exec.Command(arg0, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("executing scp (may take a long): %+v", cmd.Args)
+	return cmd.Run()
+}
+
+// splitCopyPath splits "INSTANCE:PATH" into INSTANCE and PATH.
+// ok is false for local paths.
+// Local paths that contain a colon can be specified as "./FOO:BAR".
+func splitCopyPath(s string) (instName, path string, ok bool) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	instName, path = s[:i], s[i+1:]
+	if identifiers.Validate(instName) != nil {
+		return "", "", false
+	}
+	return instName, path, true
+}
+
+func copyHostAlias(instName string) string {
+	return "lima-" + instName
+}
+
+// writeCopySSHConfig writes a temporary ssh_config that contains the host aliases for the instances.
+// The caller has to remove the file.
+func writeCopySSHConfig(instances map[string]*store.Instance) (string, error) {
+	var b strings.Builder
+	for instName, inst := range instances {
+		opts, err := sshutil.SSHOpts(inst.Dir)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "Host %s\n", copyHostAlias(instName))
+		fmt.Fprintf(&b, "  HostName 127.0.0.1\n")
+		fmt.Fprintf(&b, "  Port %d\n", inst.SSHLocalPort)
+		for _, o := range opts {
+			kv := strings.SplitN(o, "=", 2)
+			fmt.Fprintf(&b, "  %s \"%s\"\n", kv[0], kv[1])
+		}
+	}
+	f, err := os.CreateTemp("", "lima-copy-ssh-config-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func copyBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
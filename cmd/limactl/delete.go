@@ -54,6 +54,10 @@ func deleteInstance(inst *store.Instance, force bool) error {
 
 	stopInstanceForcibly(inst)
 
+	if err := store.ReleaseAddresses(inst.Name); err != nil {
+		return errors.Wrap(err, "failed to release the network addresses")
+	}
+
 	if err := os.RemoveAll(inst.Dir); err != nil {
 		return errors.Wrapf(err, "failed to remove %q", inst.Dir)
 	}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/qemu"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var diskCommand = &cli.Command{
+	Name:  "disk",
+	Usage: "Manage disks",
+	Description: "The additional disks are stored under ~/.lima/_disks, and attached to instances with the `additionalDisks` field.\n" +
+		"The additional disks are not deleted with the instances.",
+	Subcommands: []*cli.Command{
+		diskCreateCommand,
+		diskListCommand,
+		diskDeleteCommand,
+		diskResizeCommand,
+	},
+}
+
+var diskCreateCommand = &cli.Command{
+	Name:      "create",
+	Usage:     "Create an additional disk",
+	ArgsUsage: "NAME",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "size",
+			Usage:    "disk size, e.g., \"10GiB\"",
+			Required: true,
+		},
+	},
+	Action: diskCreateAction,
+}
+
+func diskCreateAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.Errorf("requires exactly 1 argument: NAME")
+	}
+	name := clicontext.Args().First()
+	size, err := units.RAMInBytes(clicontext.String("size"))
+	if err != nil {
+		return errors.Wrapf(err, "invalid size %q", clicontext.String("size"))
+	}
+	diskDir, err := store.DiskDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(diskDir); !errors.Is(err, os.ErrNotExist) {
+		return errors.Errorf("disk %q already exists (%q)", name, diskDir)
+	}
+	if err := os.MkdirAll(diskDir, 0700); err != nil {
+		return err
+	}
+	if err := qemu.CreateDisk(filepath.Join(diskDir, filenames.DataDisk), size); err != nil {
+		_ = os.RemoveAll(diskDir)
+		return err
+	}
+	logrus.Infof("Created disk %q (%q), add %q to the `additionalDisks` field of an instance to use it", name, diskDir, name)
+	return nil
+}
+
+var diskListCommand = &cli.Command{
+	Name:    "list",
+	Aliases: []string{"ls"},
+	Usage:   "List the additional disks",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "JSONify output",
+		},
+	},
+	Action: diskListAction,
+}
+
+func diskListAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 0 {
+		return errors.New("too many arguments")
+	}
+	names, err := store.Disks()
+	if err != nil {
+		return err
+	}
+	var disks []*store.Disk
+	for _, name := range names {
+		d, err := store.InspectDisk(name)
+		if err != nil {
+			logrus.WithError(err).Errorf("failed to inspect disk %q", name)
+			continue
+		}
+		disks = append(disks, d)
+	}
+	if clicontext.Bool("json") {
+		for _, d := range disks {
+			j, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(clicontext.App.Writer, string(j))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tINSTANCE\tDIR")
+	for _, d := range disks {
+		instance := "-"
+		if d.Instance != "" {
+			instance = d.Instance
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, units.BytesSize(float64(d.Size)), instance, d.Dir)
+	}
+	return w.Flush()
+}
+
+var diskDeleteCommand = &cli.Command{
+	Name:      "delete",
+	Aliases:   []string{"remove", "rm"},
+	Usage:     "Delete additional disks",
+	ArgsUsage: "NAME [NAME...]",
+	Action:    diskDeleteAction,
+}
+
+func diskDeleteAction(clicontext *cli.Context) error {
+	if clicontext.NArg() == 0 {
+		return errors.Errorf("requires at least 1 argument")
+	}
+	for _, name := range clicontext.Args().Slice() {
+		d, err := store.InspectDisk(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				logrus.Warnf("Ignoring non-existent disk %q", name)
+				continue
+			}
+			return err
+		}
+		if d.Instance != "" {
+			return errors.Errorf("disk %q is in use by instance %q", name, d.Instance)
+		}
+		users, err := store.DiskUsers(name)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return errors.Errorf("disk %q is specified in `additionalDisks` of instances %v (hint: remove it from lima.yaml of the instances first)", name, users)
+		}
+		if err := os.RemoveAll(d.Dir); err != nil {
+			return errors.Wrapf(err, "failed to remove %q", d.Dir)
+		}
+		logrus.Infof("Deleted disk %q (%q)", name, d.Dir)
+	}
+	return nil
+}
+
+var diskResizeCommand = &cli.Command{
+	Name:  "resize",
+	Usage: "Grow the disk of an instance",
+	Description: "The instance has to be stopped. The `disk` field of lima.yaml is updated too.\n" +
+		"The partition and the filesystem of the guest are grown by cloud-init on the next start.\n" +
+		"Shrinking the disk is not supported.",
+	ArgsUsage:    "INSTANCE SIZE",
+	Action:       diskResizeAction,
+	BashComplete: diskBashComplete,
+}
+
+func diskResizeAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 2 {
+		return errors.Errorf("requires exactly 2 arguments: INSTANCE SIZE")
+	}
+	instName, sizeStr := clicontext.Args().Get(0), clicontext.Args().Get(1)
+	size, err := units.RAMInBytes(sizeStr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid size %q", sizeStr)
+	}
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.Errorf("instance %q does not exist", instName)
+		}
+		return err
+	}
+	if inst.Status != store.StatusStopped {
+		return errors.Errorf("expected status %q, got %q (hint: stop the instance with `limactl stop %s`)",
+			store.StatusStopped, inst.Status, instName)
+	}
+	diffDisk := filepath.Join(inst.Dir, filenames.DiffDisk)
+	resized, err := qemu.ResizeDisk(diffDisk, size)
+	if err != nil {
+		return err
+	}
+	if resized {
+		logrus.Infof("Resized the disk of instance %q to %s", instName, sizeStr)
+	} else {
+		logrus.Infof("The disk of instance %q already has the size %s", instName, sizeStr)
+	}
+
+	yamlPath := filepath.Join(inst.Dir, filenames.LimaYAML)
+	b, err := os.ReadFile(yamlPath)
+	if err != nil {
+		return err
+	}
+	b, err = limayaml.ReplaceField(b, "disk", sizeStr)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Saving the disk size to %q", yamlPath)
+	return os.WriteFile(yamlPath, b, 0644)
+}
+
+func diskBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
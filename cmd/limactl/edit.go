@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var editCommand = &cli.Command{
+	Name:      "edit",
+	Usage:     "Edit an instance of Lima",
+	ArgsUsage: "INSTANCE",
+	Description: "Opens an editor for lima.yaml of the instance.\n" +
+		"Adding and removing reverse-sshfs mounts is applied to a running instance without restarting it.\n" +
+		"Other changes are applied on the next start of the instance.\n" +
+		"Changes that cannot be applied to an existing instance (e.g., `arch`, `images`, and shrinking `disk`) are rejected.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "tty",
+			Usage: "enable TUI interactions such as opening an editor, defaults to true when stdout is a terminal",
+			Value: isatty.IsTerminal(os.Stdout.Fd()),
+		},
+	},
+	Action:       editAction,
+	BashComplete: editBashComplete,
+}
+
+func editAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.Errorf("requires exactly 1 argument: INSTANCE")
+	}
+	if !clicontext.Bool("tty") {
+		return errors.New("requires a terminal for opening an editor")
+	}
+	instName := clicontext.Args().First()
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.Errorf("instance %q does not exist", instName)
+		}
+		return err
+	}
+	yamlPath := filepath.Join(inst.Dir, filenames.LimaYAML)
+	oldBytes, err := os.ReadFile(yamlPath)
+	if err != nil {
+		return err
+	}
+	oldY, err := limayaml.Load(oldBytes)
+	if err != nil {
+		return err
+	}
+
+	newBytes, err := openEditor(clicontext, instName, oldBytes)
+	if err != nil {
+		return err
+	}
+	if len(newBytes) == 0 {
+		logrus.Info("Aborting, as requested by saving the file with empty content")
+		return nil
+	}
+	if string(newBytes) == string(oldBytes) {
+		logrus.Info("No changes")
+		return nil
+	}
+	newY, err := limayaml.Load(newBytes)
+	if err != nil {
+		return saveRejectedYAML(newBytes, err)
+	}
+	if err := limayaml.Validate(*newY); err != nil {
+		return saveRejectedYAML(newBytes, err)
+	}
+
+	changes := limayaml.Diff(oldY, newY)
+	var rejected bool
+	for _, c := range changes {
+		if c.Class == limayaml.ChangeRejected {
+			logrus.Errorf("Rejected: %s: %s", c.Field, c.Description)
+			rejected = true
+		}
+	}
+	if rejected {
+		return saveRejectedYAML(newBytes, errors.New("the YAML contains changes that cannot be applied to an existing instance"))
+	}
+
+	if err := os.WriteFile(yamlPath, newBytes, 0644); err != nil {
+		return err
+	}
+	running := inst.Status == store.StatusRunning
+	for _, c := range changes {
+		switch {
+		case c.Class == limayaml.ChangeLive && running:
+			if err := applyLiveChange(inst, c); err != nil {
+				logrus.WithError(err).Warnf("Failed to apply %s (%s) to the running instance, it will be applied on restart", c.Field, c.Description)
+				continue
+			}
+			logrus.Infof("Applied live: %s: %s", c.Field, c.Description)
+		case running:
+			logrus.Infof("Applied on restart: %s: %s", c.Field, c.Description)
+		default:
+			logrus.Infof("Applied on the next start: %s: %s", c.Field, c.Description)
+		}
+	}
+	if len(changes) == 0 {
+		logrus.Infof("Saved %q (no effective changes)", yamlPath)
+	}
+	return nil
+}
+
+func applyLiveChange(inst *store.Instance, c limayaml.Change) error {
+	haClient, err := newHostAgentClient(inst)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.AddedMount != nil:
+		_, err = haClient.AddMount(context.TODO(), mountRequest(*c.AddedMount))
+		return err
+	case c.RemovedMount != nil:
+		return haClient.RemoveMount(context.TODO(), c.RemovedMount.MountPoint)
+	}
+	return errors.Errorf("unexpected live change %+v", c)
+}
+
+func editBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
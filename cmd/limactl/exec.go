@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+
+	"github.com/AkihiroSuda/lima/pkg/sshutil"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var execCommand = &cli.Command{
+	Name:      "exec",
+	Usage:     "Execute a command in Lima, without a login shell",
+	ArgsUsage: "INSTANCE -- COMMAND [ARGS...]",
+	Description: "Unlike `limactl shell`, the command is executed directly, without `bash --login` and without changing the directory.\n" +
+		"The exit code of the command is propagated.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "tty",
+			Usage: "allocate a pseudo-terminal (default: true when stdin and stdout are terminals)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-tty",
+			Usage: "do not allocate a pseudo-terminal",
+		},
+		&cli.StringFlag{
+			Name:  "user",
+			Usage: "guest user to execute the command as, e.g., \"root\"",
+		},
+		&cli.StringSliceFlag{
+			Name:  "env",
+			Usage: "environment variable, e.g., --env FOO=bar (can be specified multiple times)",
+		},
+	},
+	Action:       execAction,
+	BashComplete: execBashComplete,
+}
+
+func execAction(clicontext *cli.Context) error {
+	args := clicontext.Args().Slice()
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		return errors.Errorf("requires at least 2 arguments")
+	}
+	instName, argv := args[0], args[1:]
+
+	if clicontext.Bool("tty") && clicontext.Bool("no-tty") {
+		return errors.New("--tty and --no-tty are mutually exclusive")
+	}
+	tty := isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd())
+	if clicontext.IsSet("tty") {
+		tty = clicontext.Bool("tty")
+	}
+	if clicontext.Bool("no-tty") {
+		tty = false
+	}
+
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.Errorf("instance %q does not exist, run `limactl start %s` to create a new instance", instName, instName)
+		}
+		return err
+	}
+	if inst.Status != store.StatusRunning {
+		return errors.Errorf("instance %q is not running (status %q), run `limactl start %s` to start the instance", instName, inst.Status, instName)
+	}
+
+	opts := sshutil.ExecOptions{
+		Argv:   argv,
+		User:   clicontext.String("user"),
+		Env:    clicontext.StringSlice("env"),
+		TTY:    tty,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	exitCode, err := sshutil.Exec(clicontext.Context, inst.Dir, inst.SSHLocalPort, opts)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		// Exit with the exit code of the command, without printing an error
+		return cli.Exit("", exitCode)
+	}
+	return nil
+}
+
+func execBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/AkihiroSuda/lima/pkg/hostagent"
+	"github.com/AkihiroSuda/lima/pkg/hostagent/api/server"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
@@ -20,6 +30,10 @@ var hostagentCommand = &cli.Command{
 			Name:  "pidfile",
 			Usage: "PID file",
 		},
+		&cli.IntFlag{
+			Name:  "dns-port",
+			Usage: "port of the DNS forwarder (hostResolver), on 127.0.0.1",
+		},
 	},
 
 	Action: hostagentAction,
@@ -48,9 +62,54 @@ func hostagentAction(clicontext *cli.Context) error {
 	stdout := clicontext.App.Writer
 	stderr := clicontext.App.ErrWriter
 
-	ha, err := hostagent.New(instName, stdout, stderr, sigintCh)
+	var opts []hostagent.Opt
+	if dnsPort := clicontext.Int("dns-port"); dnsPort != 0 {
+		opts = append(opts, hostagent.WithDNSLocalPort(dnsPort))
+	}
+	ha, err := hostagent.New(instName, stdout, stderr, sigintCh, opts...)
+	if err != nil {
+		return err
+	}
+
+	instDir, err := store.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	socket := filepath.Join(instDir, filenames.HostAgentSock)
+	srv, err := serveHostAgentAPI(ha, socket)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		// Let the event streams deliver the "exiting" event
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if shutdownErr := srv.Shutdown(ctx); shutdownErr != nil {
+			logrus.WithError(shutdownErr).Warn("failed to shut down the host agent API server")
+		}
+		_ = os.RemoveAll(socket)
+	}()
 	return ha.Run(clicontext.Context)
 }
+
+func serveHostAgentAPI(ha *hostagent.HostAgent, socket string) (*http.Server, error) {
+	backend := &server.Backend{
+		Agent: ha,
+	}
+	r := mux.NewRouter()
+	server.AddRoutes(r, backend)
+	srv := &http.Server{Handler: r}
+	if err := os.RemoveAll(socket); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if serveErr := srv.Serve(l); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			logrus.WithError(serveErr).Warn("the host agent API server exited")
+		}
+	}()
+	return srv, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/AkihiroSuda/lima/pkg/store"
@@ -58,6 +59,9 @@ func listAction(clicontext *cli.Context) error {
 				logrus.WithError(err).Errorf("instance %q does not exist?", instName)
 				continue
 			}
+			if err := inst.LoadSnapshots(); err != nil {
+				inst.Errors = append(inst.Errors, err)
+			}
 			b, err := json.Marshal(inst)
 			if err != nil {
 				return err
@@ -68,7 +72,7 @@ func listAction(clicontext *cli.Context) error {
 	}
 
 	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
-	fmt.Fprintln(w, "NAME\tSTATUS\tSSH\tARCH\tDIR")
+	fmt.Fprintln(w, "NAME\tSTATUS\tSSH\tIP\tARCH\tDIR")
 
 	if len(instances) == 0 {
 		logrus.Warn("No instance found. Run `limactl start` to create an instance.")
@@ -83,10 +87,26 @@ func listAction(clicontext *cli.Context) error {
 		if len(inst.Errors) > 0 {
 			logrus.WithField("errors", inst.Errors).Warnf("instance %q has errors", instName)
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+		ssh := "-"
+		if inst.SSHLocalPort != 0 {
+			ssh = fmt.Sprintf("127.0.0.1:%d", inst.SSHLocalPort)
+		}
+		status := inst.Status
+		if len(inst.DegradedMounts) > 0 {
+			status += " (degraded mounts)"
+			logrus.Warnf("instance %q has degraded mounts: %v (being remounted by the host agent)", instName, inst.DegradedMounts)
+		} else if inst.Degraded {
+			status += " (degraded)"
+		}
+		ip := "-"
+		if len(inst.IPAddresses) > 0 {
+			ip = strings.Join(inst.IPAddresses, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
 			inst.Name,
-			inst.Status,
-			fmt.Sprintf("127.0.0.1:%d", inst.SSHLocalPort),
+			status,
+			ssh,
+			ip,
 			inst.Arch,
 			inst.Dir,
 		)
@@ -44,10 +44,18 @@ func newApp() *cli.App {
 		startCommand,
 		stopCommand,
 		shellCommand,
+		execCommand,
+		copyCommand,
+		mountCommand,
+		editCommand,
 		listCommand,
 		deleteCommand,
 		validateCommand,
 		pruneCommand,
+		cacheCommand,
+		snapshotCommand,
+		diskCommand,
+		networkCommand,
 		completionCommand,
 		hostagentCommand, // hidden
 	}
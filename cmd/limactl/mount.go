@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	hostagentclient "github.com/AkihiroSuda/lima/pkg/hostagent/api/client"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/localpathutil"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var mountCommand = &cli.Command{
+	Name:  "mount",
+	Usage: "Manage the mounts of an instance",
+	Description: "The changes are saved to lima.yaml of the instance.\n" +
+		"The mounts of a running instance are added and removed without restarting the instance.\n" +
+		"Only reverse-sshfs mounts can be added to (and removed from) a running instance.",
+	Subcommands: []*cli.Command{
+		mountAddCommand,
+		mountRemoveCommand,
+		mountListCommand,
+	},
+}
+
+var mountAddCommand = &cli.Command{
+	Name:      "add",
+	Usage:     "Add a mount",
+	ArgsUsage: "INSTANCE HOSTPATH",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "writable",
+			Usage: "make the mount writable",
+		},
+		&cli.StringFlag{
+			Name:  "mount-point",
+			Usage: "path in the guest (default: same as HOSTPATH)",
+		},
+		&cli.StringFlag{
+			Name:  "mount-type",
+			Usage: "mount type (\"reverse-sshfs\", \"9p\", or \"virtiofs\"; default: `mountType` of the instance)",
+		},
+	},
+	Action:       mountAddAction,
+	BashComplete: mountBashComplete,
+}
+
+func mountAddAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 2 {
+		return errors.Errorf("requires exactly 2 arguments: INSTANCE HOSTPATH")
+	}
+	inst, y, err := loadInstanceRawYAML(clicontext.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	location, err := localpathutil.Expand(clicontext.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	location, err = filepath.Abs(location)
+	if err != nil {
+		return err
+	}
+	m := limayaml.Mount{
+		Location:   location,
+		MountPoint: clicontext.String("mount-point"),
+		Writable:   clicontext.Bool("writable"),
+		MountType:  clicontext.String("mount-type"),
+	}
+	y.Mounts = append(y.Mounts, m)
+	if err := limayaml.Validate(*y); err != nil {
+		return err
+	}
+
+	// lima.yaml is saved first, so that the mount is not left unsaved when saving fails
+	restore, err := saveMounts(inst, y.Mounts)
+	if err != nil {
+		return err
+	}
+	if inst.Status == store.StatusRunning {
+		haClient, err := newHostAgentClient(inst)
+		if err != nil {
+			restoreMounts(restore)
+			return err
+		}
+		added, err := haClient.AddMount(context.TODO(), mountRequest(m))
+		if err != nil {
+			restoreMounts(restore)
+			return errors.Wrap(err, "failed to add the mount to the running instance (hint: stop the instance to add a 9p or virtiofs mount)")
+		}
+		logrus.Infof("Mounted %q on %q", added.Location, added.MountPoint)
+	}
+	return nil
+}
+
+var mountRemoveCommand = &cli.Command{
+	Name:         "rm",
+	Aliases:      []string{"remove"},
+	Usage:        "Remove a mount",
+	ArgsUsage:    "INSTANCE HOSTPATH|MOUNTPOINT",
+	Action:       mountRemoveAction,
+	BashComplete: mountBashComplete,
+}
+
+func mountRemoveAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 2 {
+		return errors.Errorf("requires exactly 2 arguments: INSTANCE HOSTPATH|MOUNTPOINT")
+	}
+	inst, y, err := loadInstanceRawYAML(clicontext.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	arg := clicontext.Args().Get(1)
+	filled := *y
+	filled.Mounts = append([]limayaml.Mount{}, y.Mounts...)
+	limayaml.FillDefault(&filled)
+	idx := -1
+	var found limayaml.Mount
+	for i, f := range filled.Mounts {
+		location, _ := localpathutil.Expand(f.Location)
+		if arg == f.MountPoint || arg == f.Location || arg == location {
+			idx, found = i, f
+			break
+		}
+	}
+	if idx < 0 {
+		return errors.Errorf("no mount found for %q in instance %q", arg, inst.Name)
+	}
+
+	y.Mounts = append(y.Mounts[:idx:idx], y.Mounts[idx+1:]...)
+	restore, err := saveMounts(inst, y.Mounts)
+	if err != nil {
+		return err
+	}
+	if inst.Status == store.StatusRunning {
+		haClient, err := newHostAgentClient(inst)
+		if err != nil {
+			restoreMounts(restore)
+			return err
+		}
+		if err := haClient.RemoveMount(context.TODO(), found.MountPoint); err != nil {
+			restoreMounts(restore)
+			return errors.Wrap(err, "failed to remove the mount from the running instance (hint: stop the instance to remove a 9p or virtiofs mount)")
+		}
+		logrus.Infof("Unmounted %q", found.MountPoint)
+	}
+	return nil
+}
+
+var mountListCommand = &cli.Command{
+	Name:         "ls",
+	Aliases:      []string{"list"},
+	Usage:        "List the mounts",
+	ArgsUsage:    "INSTANCE",
+	Action:       mountListAction,
+	BashComplete: mountBashComplete,
+}
+
+func mountListAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.Errorf("requires exactly 1 argument: INSTANCE")
+	}
+	inst, y, err := loadInstanceRawYAML(clicontext.Args().First())
+	if err != nil {
+		return err
+	}
+	var mounts []hostagentapi.Mount
+	if inst.Status == store.StatusRunning {
+		haClient, err := newHostAgentClient(inst)
+		if err != nil {
+			return err
+		}
+		mounts, err = haClient.Mounts(context.TODO())
+		if err != nil {
+			return err
+		}
+	} else {
+		limayaml.FillDefault(y)
+		for _, f := range y.Mounts {
+			location, _ := localpathutil.Expand(f.Location)
+			mounts = append(mounts, hostagentapi.Mount{
+				Location:   location,
+				MountPoint: f.MountPoint,
+				Writable:   f.Writable,
+				MountType:  f.MountType,
+			})
+		}
+	}
+	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "LOCATION\tMOUNTPOINT\tTYPE\tWRITABLE")
+	for _, m := range mounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", m.Location, m.MountPoint, m.MountType, m.Writable)
+	}
+	return w.Flush()
+}
+
+// mountRequest returns the request for adding the mount to the running instance, with the reverse-sshfs options.
+func mountRequest(m limayaml.Mount) hostagentapi.Mount {
+	return hostagentapi.Mount{
+		Location:   m.Location,
+		MountPoint: m.MountPoint,
+		Writable:   m.Writable,
+		MountType:  m.MountType,
+		SSHFS: &hostagentapi.SSHFS{
+			Cache:          m.SSHFS.Cache,
+			FollowSymlinks: m.SSHFS.FollowSymlinks,
+		},
+		UID:   m.UID,
+		GID:   m.GID,
+		Umask: m.Umask,
+	}
+}
+
+// loadInstanceRawYAML loads lima.yaml of the instance without filling the default values.
+func loadInstanceRawYAML(instName string) (*store.Instance, *limayaml.LimaYAML, error) {
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, errors.Errorf("instance %q does not exist", instName)
+		}
+		return nil, nil, err
+	}
+	if len(inst.Errors) > 0 {
+		return nil, nil, errors.Errorf("instance %q has errors: %v", instName, inst.Errors)
+	}
+	b, err := os.ReadFile(filepath.Join(inst.Dir, filenames.LimaYAML))
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := limayaml.LoadRaw(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inst, y, nil
+}
+
+// saveMounts replaces the `mounts` field of lima.yaml of the instance.
+// The returned function restores the original lima.yaml.
+func saveMounts(inst *store.Instance, mounts []limayaml.Mount) (func() error, error) {
+	yamlPath := filepath.Join(inst.Dir, filenames.LimaYAML)
+	orig, err := os.ReadFile(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if len(mounts) > 0 {
+		v = mounts
+	}
+	b, err := limayaml.ReplaceField(orig, "mounts", v)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Saving the mounts to %q (the comments inside the `mounts` field are not preserved)", yamlPath)
+	if err := os.WriteFile(yamlPath, b, 0644); err != nil {
+		return nil, err
+	}
+	restore := func() error {
+		return os.WriteFile(yamlPath, orig, 0644)
+	}
+	return restore, nil
+}
+
+func restoreMounts(restore func() error) {
+	if err := restore(); err != nil {
+		logrus.WithError(err).Warn("failed to restore the mounts in lima.yaml")
+	}
+}
+
+func newHostAgentClient(inst *store.Instance) (hostagentclient.HostAgentClient, error) {
+	haSock := filepath.Join(inst.Dir, filenames.HostAgentSock)
+	haClient, err := hostagentclient.NewHostAgentClient(haSock)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to the host agent API %q", haSock)
+	}
+	return haClient, nil
+}
+
+func mountBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
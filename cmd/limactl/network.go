@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var networkCommand = &cli.Command{
+	Name:  "network",
+	Usage: "Manage networks for instance-to-instance communication",
+	Description: "The networks are stored under ~/.lima/_networks, and attached to instances with `networks: [{type: lima, network: NAME}]`.\n" +
+		"The instances on the same network can reach each other by the static addresses, and by the names \"INSTANCE\" and \"lima-INSTANCE\".",
+	Subcommands: []*cli.Command{
+		networkCreateCommand,
+		networkListCommand,
+		networkDeleteCommand,
+	},
+}
+
+var networkCreateCommand = &cli.Command{
+	Name:      "create",
+	Usage:     "Create a network",
+	ArgsUsage: "NAME",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "subnet",
+			Usage: "IPv4 subnet, e.g., \"192.168.106.0/24\" (default: an unused /24 subnet)",
+		},
+	},
+	Action: networkCreateAction,
+}
+
+func networkCreateAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.Errorf("requires exactly 1 argument: NAME")
+	}
+	name := clicontext.Args().First()
+	nw, err := store.CreateNetwork(name, clicontext.String("subnet"))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Created network %q (subnet %s), add {type: \"lima\", network: %q} to the `networks` field of instances to use it",
+		name, nw.Subnet, name)
+	return nil
+}
+
+var networkListCommand = &cli.Command{
+	Name:    "list",
+	Aliases: []string{"ls"},
+	Usage:   "List the networks",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "JSONify output",
+		},
+	},
+	Action: networkListAction,
+}
+
+func networkListAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 0 {
+		return errors.New("too many arguments")
+	}
+	names, err := store.Networks()
+	if err != nil {
+		return err
+	}
+	var networks []*store.Network
+	for _, name := range names {
+		nw, err := store.InspectNetwork(name)
+		if err != nil {
+			logrus.WithError(err).Errorf("failed to inspect network %q", name)
+			continue
+		}
+		networks = append(networks, nw)
+	}
+	if clicontext.Bool("json") {
+		for _, nw := range networks {
+			j, err := json.Marshal(nw)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(clicontext.App.Writer, string(j))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSUBNET\tINSTANCES\tDIR")
+	for _, nw := range networks {
+		instances := "-"
+		if len(nw.Addresses) > 0 {
+			var ss []string
+			for instName, ip := range nw.Addresses {
+				ss = append(ss, instName+"="+ip)
+			}
+			sort.Strings(ss)
+			instances = strings.Join(ss, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", nw.Name, nw.Subnet, instances, nw.Dir)
+	}
+	return w.Flush()
+}
+
+var networkDeleteCommand = &cli.Command{
+	Name:      "delete",
+	Aliases:   []string{"remove", "rm"},
+	Usage:     "Delete networks",
+	ArgsUsage: "NAME [NAME...]",
+	Action:    networkDeleteAction,
+}
+
+func networkDeleteAction(clicontext *cli.Context) error {
+	if clicontext.NArg() == 0 {
+		return errors.Errorf("requires at least 1 argument")
+	}
+	for _, name := range clicontext.Args().Slice() {
+		nw, err := store.InspectNetwork(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				logrus.Warnf("Ignoring non-existent network %q", name)
+				continue
+			}
+			return err
+		}
+		for instName := range nw.Addresses {
+			// Addresses of removed instances are stale, and ignored
+			if _, err := store.Inspect(instName); err == nil {
+				return errors.Errorf("network %q is in use by instance %q (hint: delete the instance first)", name, instName)
+			}
+		}
+		if err := os.RemoveAll(nw.Dir); err != nil {
+			return errors.Wrapf(err, "failed to remove %q", nw.Dir)
+		}
+		logrus.Infof("Deleted network %q (%q)", name, nw.Dir)
+	}
+	return nil
+}
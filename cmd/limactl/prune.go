@@ -2,7 +2,8 @@ package main
 
 import (
 	"os"
-	"path/filepath"
+
+	"github.com/AkihiroSuda/lima/pkg/downloader"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -10,16 +11,15 @@ import (
 
 var pruneCommand = &cli.Command{
 	Name:   "prune",
-	Usage:  "Prune garbage objects",
+	Usage:  "Prune garbage objects (the whole cache). See also `limactl cache prune`.",
 	Action: pruneAction,
 }
 
 func pruneAction(clicontext *cli.Context) error {
-	ucd, err := os.UserCacheDir()
+	cacheDir, err := downloader.DefaultCacheDir()
 	if err != nil {
 		return err
 	}
-	cacheDir := filepath.Join(ucd, "lima")
 	logrus.Infof("Pruning %q", cacheDir)
 	return os.RemoveAll(cacheDir)
 }
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/AkihiroSuda/lima/pkg/envutil"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
 	"github.com/AkihiroSuda/lima/pkg/sshutil"
 	"github.com/AkihiroSuda/lima/pkg/store"
 	"github.com/alessio/shellescape"
@@ -27,6 +29,24 @@ var shellCommand = &cli.Command{
 			Name:  "workdir",
 			Usage: "working directory",
 		},
+		&cli.StringSliceFlag{
+			Name:  "env",
+			Usage: "environment variable, e.g., --env FOO=bar (can be specified multiple times)",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-env",
+			Usage: "propagate the environment variables of the host, except PATH, HOME, SSH_*, etc.",
+		},
+		&cli.StringSliceFlag{
+			Name:    "preserve-env-allow",
+			Usage:   "with --preserve-env, propagate only the variables that match the patterns, e.g., \"GO*\"",
+			EnvVars: []string{"LIMA_SHELLENV_ALLOW"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "preserve-env-deny",
+			Usage:   "with --preserve-env, do not propagate the variables that match the patterns, e.g., \"AWS_*\"",
+			EnvVars: []string{"LIMA_SHELLENV_DENY"},
+		},
 	},
 	Action:       shellAction,
 	BashComplete: shellBashComplete,
@@ -54,8 +74,13 @@ func shellAction(clicontext *cli.Context) error {
 		}
 		return err
 	}
-	if inst.Status == store.StatusStopped {
+	switch inst.Status {
+	case store.StatusRunning:
+	case store.StatusStopped:
 		return errors.Errorf("instance %q is stopped, run `limactl start %s` to start the instance", instName, instName)
+	default:
+		return errors.Errorf("instance %q is not running (status %q, errors %v), run `limactl stop -f %s` and `limactl start %s` to restart the instance",
+			instName, inst.Status, inst.Errors, instName, instName)
 	}
 	y, err := inst.LoadYAML()
 	if err != nil {
@@ -64,36 +89,31 @@ func shellAction(clicontext *cli.Context) error {
 
 	// When workDir is explicitly set, the shell MUST have workDir as the cwd, or exit with an error.
 	//
-	// changeDirCmd := "cd workDir || exit 1"                  if workDir != ""
-	//              := "cd hostCurrentDir || cd hostHomeDir"   if workDir == ""
-	var changeDirCmd string
+	// changeDirCmd := "cd workDir || exit 1"  if workDir != ""
+	//              := "cd guestCurrentDir"    if workDir == "" and the host cwd is mounted
+	//              := "cd"                    otherwise (the guest home)
+	changeDirCmd := "cd"
 	if workDir := clicontext.String("workdir"); workDir != "" {
-		changeDirCmd = fmt.Sprintf("cd %q || exit 1", workDir)
-		// FIXME: check whether y.Mounts contains the home, not just len > 0
-	} else if len(y.Mounts) > 0 {
-		hostCurrentDir, err := os.Getwd()
-		if err == nil {
-			changeDirCmd = fmt.Sprintf("cd %q", hostCurrentDir)
-		} else {
-			changeDirCmd = "false"
-			logrus.WithError(err).Warn("failed to get the current directory")
-		}
-		hostHomeDir, err := os.UserHomeDir()
-		if err == nil {
-			changeDirCmd = fmt.Sprintf("%s || cd %q", changeDirCmd, hostHomeDir)
-		} else {
-			logrus.WithError(err).Warn("failed to get the home directory")
-		}
+		changeDirCmd = fmt.Sprintf("cd %s || exit 1", shellescape.Quote(workDir))
+	} else if hostCurrentDir, err := os.Getwd(); err != nil {
+		logrus.WithError(err).Warn("failed to get the current directory, using the home directory of the guest")
+	} else if guestCurrentDir, err := limayaml.GuestPath(y.Mounts, hostCurrentDir); err != nil {
+		logrus.WithError(err).Warn("the current directory is not mounted in the guest, using the home directory of the guest")
 	} else {
-		logrus.Debug("the host home does not seem mounted, so the guest shell will have a different cwd")
-	}
-
-	if changeDirCmd == "" {
-		changeDirCmd = "false"
+		changeDirCmd = fmt.Sprintf("cd %s", shellescape.Quote(guestCurrentDir))
 	}
 	logrus.Debugf("changeDirCmd=%q", changeDirCmd)
 
-	script := fmt.Sprintf("%s ; exec bash --login", changeDirCmd)
+	env, err := shellEnv(clicontext)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("%s ; exec ", changeDirCmd)
+	if len(env) > 0 {
+		// The variables are set before the login scripts of the guest, so they may be overridden by the login scripts
+		script += "env " + shellescape.QuoteCommand(env) + " "
+	}
+	script += "bash --login"
 	if clicontext.NArg() > 1 {
 		script += fmt.Sprintf(" -c %q", shellescape.QuoteCommand(clicontext.Args().Tail()))
 	}
@@ -128,6 +148,22 @@ func shellAction(clicontext *cli.Context) error {
 	return cmd.Run()
 }
 
+// shellEnv returns the environment variables ("KEY=VALUE") specified with --env and --preserve-env.
+// The variables specified with --env take precedence.
+func shellEnv(clicontext *cli.Context) ([]string, error) {
+	var env []string
+	if clicontext.Bool("preserve-env") {
+		env = envutil.Filter(os.Environ(), clicontext.StringSlice("preserve-env-allow"), clicontext.StringSlice("preserve-env-deny"))
+	}
+	for _, f := range clicontext.StringSlice("env") {
+		if _, _, err := envutil.ParseKeyValue(f); err != nil {
+			return nil, errors.Wrapf(err, "invalid --env %q", f)
+		}
+		env = append(env, f)
+	}
+	return env, nil
+}
+
 func shellBashComplete(clicontext *cli.Context) {
 	bashCompleteInstanceNames(clicontext)
 }
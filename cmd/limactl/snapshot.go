@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/qemu"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var snapshotCommand = &cli.Command{
+	Name:  "snapshot",
+	Usage: "Manage instance snapshots",
+	Description: "Snapshots are stored in the diffdisk of the instance, as qcow2 internal snapshots.\n" +
+		"The snapshots of a running instance contain the VM state (RAM) too.\n" +
+		"The snapshots of a running instance cannot be created nor applied when the instance has 9p or virtiofs mounts;\n" +
+		"stop the instance first.",
+	Subcommands: []*cli.Command{
+		snapshotCreateCommand,
+		snapshotApplyCommand,
+		snapshotDeleteCommand,
+		snapshotListCommand,
+	},
+}
+
+var snapshotCreateCommand = &cli.Command{
+	Name:         "create",
+	Aliases:      []string{"save"},
+	Usage:        "Create a snapshot",
+	ArgsUsage:    "INSTANCE TAG",
+	Action:       snapshotCreateAction,
+	BashComplete: snapshotBashComplete,
+}
+
+func snapshotCreateAction(clicontext *cli.Context) error {
+	inst, tag, err := snapshotArgs(clicontext)
+	if err != nil {
+		return err
+	}
+	for _, f := range inst.Snapshots {
+		if f == tag {
+			return errors.Errorf("snapshot %q already exists", tag)
+		}
+	}
+	if err := validateOnlineSnapshot(inst); err != nil {
+		return err
+	}
+	if err := qemu.CreateSnapshot(inst.Dir, inst.Status == store.StatusRunning, tag); err != nil {
+		return err
+	}
+	logrus.Infof("Created snapshot %q of instance %q", tag, inst.Name)
+	return nil
+}
+
+var snapshotApplyCommand = &cli.Command{
+	Name:         "apply",
+	Aliases:      []string{"load"},
+	Usage:        "Apply (revert to) a snapshot",
+	ArgsUsage:    "INSTANCE TAG",
+	Action:       snapshotApplyAction,
+	BashComplete: snapshotBashComplete,
+}
+
+func snapshotApplyAction(clicontext *cli.Context) error {
+	inst, tag, err := snapshotArgs(clicontext)
+	if err != nil {
+		return err
+	}
+	if err := validateOnlineSnapshot(inst); err != nil {
+		return err
+	}
+	if err := qemu.ApplySnapshot(inst.Dir, inst.Status == store.StatusRunning, tag); err != nil {
+		return err
+	}
+	logrus.Infof("Applied snapshot %q to instance %q", tag, inst.Name)
+	return nil
+}
+
+var snapshotDeleteCommand = &cli.Command{
+	Name:         "delete",
+	Aliases:      []string{"remove", "rm"},
+	Usage:        "Delete a snapshot",
+	ArgsUsage:    "INSTANCE TAG",
+	Action:       snapshotDeleteAction,
+	BashComplete: snapshotBashComplete,
+}
+
+func snapshotDeleteAction(clicontext *cli.Context) error {
+	inst, tag, err := snapshotArgs(clicontext)
+	if err != nil {
+		return err
+	}
+	if err := qemu.DeleteSnapshot(inst.Dir, inst.Status == store.StatusRunning, tag); err != nil {
+		return err
+	}
+	logrus.Infof("Deleted snapshot %q of instance %q", tag, inst.Name)
+	return nil
+}
+
+var snapshotListCommand = &cli.Command{
+	Name:      "list",
+	Aliases:   []string{"ls"},
+	Usage:     "List snapshots",
+	ArgsUsage: "INSTANCE",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "Only show tags",
+		},
+	},
+	Action:       snapshotListAction,
+	BashComplete: snapshotBashComplete,
+}
+
+func snapshotListAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.Errorf("requires exactly 1 argument")
+	}
+	inst, err := store.Inspect(clicontext.Args().First())
+	if err != nil {
+		return err
+	}
+	snapshots, err := qemu.ListSnapshots(inst.Dir)
+	if err != nil {
+		return err
+	}
+	if clicontext.Bool("quiet") {
+		for _, f := range snapshots {
+			fmt.Fprintln(clicontext.App.Writer, f.Name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(clicontext.App.Writer, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "TAG\tVM STATE\tDATE")
+	for _, f := range snapshots {
+		vmState := "-"
+		if f.VMStateSize > 0 {
+			vmState = units.BytesSize(float64(f.VMStateSize))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, vmState, f.Date().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// snapshotArgs returns the instance and the tag specified as `INSTANCE TAG`.
+func snapshotArgs(clicontext *cli.Context) (*store.Instance, string, error) {
+	if clicontext.NArg() != 2 {
+		return nil, "", errors.Errorf("requires exactly 2 arguments")
+	}
+	instName, tag := clicontext.Args().Get(0), clicontext.Args().Get(1)
+	if err := qemu.ValidateSnapshotTag(tag); err != nil {
+		return nil, "", err
+	}
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return nil, "", err
+	}
+	switch inst.Status {
+	case store.StatusRunning, store.StatusStopped:
+	default:
+		return nil, "", errors.Errorf("expected status %q or %q, got %q", store.StatusRunning, store.StatusStopped, inst.Status)
+	}
+	if err := inst.LoadSnapshots(); err != nil {
+		return nil, "", err
+	}
+	return inst, tag, nil
+}
+
+// validateOnlineSnapshot validates the instance when it is running.
+func validateOnlineSnapshot(inst *store.Instance) error {
+	if inst.Status != store.StatusRunning {
+		return nil
+	}
+	y, err := inst.LoadYAML()
+	if err != nil {
+		return err
+	}
+	return qemu.ValidateOnlineSnapshot(y)
+}
+
+func snapshotBashComplete(clicontext *cli.Context) {
+	bashCompleteInstanceNames(clicontext)
+}
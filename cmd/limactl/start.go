@@ -107,11 +107,7 @@ func loadOrCreateInstance(clicontext *cli.Context) (*store.Instance, error) {
 		if !clicontext.Bool("tty") {
 			return nil, err
 		}
-		rejectedYAML := "lima.REJECTED.yaml"
-		if writeErr := os.WriteFile(rejectedYAML, yBytes, 0644); writeErr != nil {
-			return nil, errors.Wrapf(err, "the YAML is invalid, attempted to save the buffer as %q but failed: %v", rejectedYAML, writeErr)
-		}
-		return nil, errors.Wrapf(err, "the YAML is invalid, saved the buffer as %q", rejectedYAML)
+		return nil, saveRejectedYAML(yBytes, err)
 	}
 	if err := os.MkdirAll(instDir, 0700); err != nil {
 		return nil, err
@@ -140,7 +136,7 @@ func openEditor(clicontext *cli.Context, name string, initialContent []byte) ([]
 	if name == DefaultInstanceName {
 		hdr += "# - In most cases, you do not need to modify this file.\n"
 	}
-	hdr += "# - To cancel starting Lima, just save this file as an empty file.\n"
+	hdr += "# - To cancel, just save this file as an empty file.\n"
 	hdr += "\n"
 	if err := ioutil.WriteFile(tmpYAMLPath,
 		append([]byte(hdr), initialContent...),
@@ -169,6 +165,16 @@ func openEditor(clicontext *cli.Context, name string, initialContent []byte) ([]
 	return []byte(modifiedExclHdr), nil
 }
 
+// saveRejectedYAML saves the rejected buffer as "lima.REJECTED.yaml" in the current directory.
+// saveRejectedYAML is used by both `limactl start` and `limactl edit`.
+func saveRejectedYAML(b []byte, err error) error {
+	rejectedYAML := "lima.REJECTED.yaml"
+	if writeErr := os.WriteFile(rejectedYAML, b, 0644); writeErr != nil {
+		return errors.Wrapf(err, "the YAML is rejected, attempted to save the buffer as %q but failed: %v", rejectedYAML, writeErr)
+	}
+	return errors.Wrapf(err, "the YAML is rejected, saved the buffer as %q", rejectedYAML)
+}
+
 func startAction(clicontext *cli.Context) error {
 	inst, err := loadOrCreateInstance(clicontext)
 	if err != nil {
@@ -5,10 +5,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	hostagentclient "github.com/AkihiroSuda/lima/pkg/hostagent/api/client"
 	"github.com/AkihiroSuda/lima/pkg/store"
 	"github.com/AkihiroSuda/lima/pkg/store/filenames"
 	"github.com/pkg/errors"
@@ -59,21 +61,33 @@ func stopInstanceGracefully(inst *store.Instance) error {
 		return errors.Errorf("expected status %q, got %q", store.StatusRunning, inst.Status)
 	}
 
-	logrus.Infof("Sending SIGINT to hostagent process %d", inst.HostAgentPID)
-	if err := syscall.Kill(inst.HostAgentPID, syscall.SIGINT); err != nil {
-		logrus.Error(err)
+	haSock := filepath.Join(inst.Dir, filenames.HostAgentSock)
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	haClient, err := hostagentclient.Connect(ctx, haSock)
+	cancel()
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to connect to the host agent API %q, falling back to SIGINT", haSock)
+		return stopInstanceWithSignal(inst)
 	}
 
 	logrus.Info("Waiting for the host agent and the qemu processes to shut down")
-	return waitForHostAgentTermination(context.TODO(), inst)
+	return shutdownHostAgent(context.TODO(), haClient)
 }
 
-func waitForHostAgentTermination(ctx context.Context, inst *store.Instance) error {
+// shutdownHostAgent requests the host agent to shut down via the API, and waits for the "exiting" event.
+func shutdownHostAgent(ctx context.Context, haClient hostagentclient.HostAgentClient) error {
 	ctx2, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
-	var receivedExitingEvent bool
+	var (
+		receivedExitingEvent bool
+		subscribedCh         = make(chan struct{})
+		subscribedOnce       sync.Once
+		eventsErrCh          = make(chan error, 1)
+	)
 	onEvent := func(ev hostagentapi.Event) bool {
+		// The first event is the latest event emitted before the subscription
+		subscribedOnce.Do(func() { close(subscribedCh) })
 		if len(ev.Status.Errors) > 0 {
 			logrus.Errorf("%+v", ev.Status.Errors)
 		}
@@ -83,21 +97,64 @@ func waitForHostAgentTermination(ctx context.Context, inst *store.Instance) erro
 		}
 		return false
 	}
+	go func() {
+		eventsErrCh <- haClient.Events(ctx2, onEvent)
+	}()
+
+	select {
+	case <-subscribedCh:
+	case err := <-eventsErrCh:
+		return errors.Wrap(err, "failed to watch the host agent events")
+	}
 
-	haStdoutPath := filepath.Join(inst.Dir, filenames.HostAgentStdoutLog)
-	haStderrPath := filepath.Join(inst.Dir, filenames.HostAgentStderrLog)
-
-	if err := hostagentapi.WatchEvents(ctx2, haStdoutPath, haStderrPath, onEvent); err != nil {
+	logrus.Info("Requesting the host agent to shut down")
+	if err := haClient.Shutdown(ctx2); err != nil {
 		return err
 	}
 
+	if err := <-eventsErrCh; err != nil && !receivedExitingEvent {
+		return errors.Wrap(err, "did not receive an event with the \"exiting\" status")
+	}
 	if !receivedExitingEvent {
 		return errors.New("did not receive an event with the \"exiting\" status")
 	}
-
 	return nil
 }
 
+// stopInstanceWithSignal sends SIGINT to the host agent, and waits for the host agent process to exit.
+// stopInstanceWithSignal is used only when the host agent API is not available.
+func stopInstanceWithSignal(inst *store.Instance) error {
+	logrus.Infof("Sending SIGINT to hostagent process %d", inst.HostAgentPID)
+	if err := syscall.Kill(inst.HostAgentPID, syscall.SIGINT); err != nil {
+		return err
+	}
+
+	logrus.Info("Waiting for the host agent and the qemu processes to shut down")
+	return waitForHostAgentTermination(context.TODO(), inst)
+}
+
+func waitForHostAgentTermination(ctx context.Context, inst *store.Instance) error {
+	ctx2, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	haPIDPath := filepath.Join(inst.Dir, filenames.HostAgentPID)
+	for {
+		// The host agent removes the pid file on exit
+		if _, err := os.Stat(haPIDPath); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err := syscall.Kill(inst.HostAgentPID, 0); errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+		select {
+		case <-ctx2.Done():
+			return errors.Errorf("the host agent process %d did not exit (hint: see %q)",
+				inst.HostAgentPID, filepath.Join(inst.Dir, filenames.HostAgentStderrLog))
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
 func stopInstanceForcibly(inst *store.Instance) {
 	if inst.QemuPID > 0 {
 		logrus.Infof("Sending SIGKILL to the QEMU process %d", inst.QemuPID)
@@ -2,27 +2,40 @@ package cidata
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/AkihiroSuda/lima/pkg/downloader"
 	"github.com/AkihiroSuda/lima/pkg/iso9660util"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
-	"github.com/AkihiroSuda/lima/pkg/localpathutil"
+	"github.com/AkihiroSuda/lima/pkg/qemu"
 	"github.com/AkihiroSuda/lima/pkg/sshutil"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-const NerdctlVersion = "0.8.3"
+// LimaNetwork is a network of type "lima" that the instance is connected to (see store.Network).
+type LimaNetwork struct {
+	// Address is the address of the instance, in the CIDR notation
+	Address string
+	// Addresses are the addresses of the instances on the network, keyed by the instance names
+	Addresses map[string]string
+}
 
-func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
+// GenerateISO9660 generates the cloud-init ISO9660 image (cidata).
+// downloadOpts are passed to downloader.Download, in addition to the default options.
+// hostResolverPort is the port of the DNS forwarder of the host agent (`hostResolver`), or 0 when it is disabled.
+// limaNetworks are the networks of type "lima" in y.Networks, keyed by the network names.
+func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML, hostResolverPort int, limaNetworks map[string]LimaNetwork, downloadOpts ...downloader.Opt) error {
 	if err := limayaml.ValidateRaw(*y); err != nil {
 		return err
 	}
@@ -35,11 +48,45 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 		return err
 	}
 	args := TemplateArgs{
-		Name:       name,
-		User:       u.Username,
-		UID:        uid,
-		Provision:  y.Provision,
-		Containerd: Containerd{System: *y.Containerd.System, User: *y.Containerd.User},
+		Name:             name,
+		User:             u.Username,
+		UID:              uid,
+		Containerd:       Containerd{System: *y.Containerd.System, User: *y.Containerd.User},
+		HostResolverPort: hostResolverPort,
+	}
+	order, err := limayaml.ProvisionOrder(y.Provision)
+	if err != nil {
+		return err
+	}
+	for _, i := range order {
+		f := y.Provision[i]
+		args.Provision = append(args.Provision, Provision{
+			Name:    f.Name,
+			Mode:    f.Mode,
+			After:   f.After,
+			RunOnce: f.RunOnce,
+			Script:  f.Script,
+		})
+	}
+	for _, ip := range y.DNS.Servers {
+		args.DNSServers = append(args.DNSServers, ip.String())
+	}
+	args.DNSSearch = y.DNS.Search
+	proxy := y.Proxy
+	if *proxy.FromEnv {
+		proxy = proxyFromEnv(proxy, os.Getenv)
+	}
+	args.Proxy, err = guestProxy(proxy)
+	if err != nil {
+		return err
+	}
+	var envNames []string
+	for k := range y.Env {
+		envNames = append(envNames, k)
+	}
+	sort.Strings(envNames)
+	for _, k := range envNames {
+		args.Env = append(args.Env, EnvVar{Name: k, Value: y.Env[k]})
 	}
 
 	pubKeys := sshutil.DefaultPubKeys()
@@ -50,12 +97,49 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 		args.SSHPubKeys = append(args.SSHPubKeys, f.Content)
 	}
 
-	for _, f := range y.Mounts {
-		expanded, err := localpathutil.Expand(f.Location)
-		if err != nil {
-			return err
+	for i, f := range y.Mounts {
+		mount := Mount{
+			MountPoint: f.MountPoint,
+			Type:       f.MountType,
+			Writable:   f.Writable,
+		}
+		if f.MountType != limayaml.ReverseSSHFS {
+			mount.Tag = qemu.MountTag(i)
+		}
+		args.Mounts = append(args.Mounts, mount)
+	}
+
+	// The default network configuration of cloud-init only enables DHCP on the first interface,
+	// without the custom DNS configuration
+	if len(y.Networks) > 1 || len(args.DNSServers) > 0 || len(args.DNSSearch) > 0 {
+		for i, f := range y.Networks {
+			nw := Network{
+				MACAddress: qemu.MACAddress(name, i, f),
+			}
+			switch f.Type {
+			case limayaml.NetworkUser:
+				nw.DNS = len(args.DNSServers) > 0 || len(args.DNSSearch) > 0
+			case limayaml.NetworkLima:
+				// The address is allocated by pkg/start
+				limaNetwork, ok := limaNetworks[f.Network]
+				if !ok || limaNetwork.Address == "" {
+					return errors.Errorf("no address is allocated in network %q", f.Network)
+				}
+				nw.Address = limaNetwork.Address
+			default:
+				// Prefer the default route of the user network
+				nw.Metric = 200
+			}
+			args.Networks = append(args.Networks, nw)
 		}
-		args.Mounts = append(args.Mounts, expanded)
+	}
+	args.Hosts = Hosts(y, limaNetworks)
+
+	for i, f := range y.AdditionalDisks {
+		args.Disks = append(args.Disks, Disk{
+			Name:   f,
+			Serial: qemu.DiskSerial(i),
+		})
 	}
 
 	if err := ValidateTemplateArgs(args); err != nil {
@@ -82,6 +166,17 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 		})
 	}
 
+	if len(args.Networks) > 0 {
+		networkConfig, err := GenerateNetworkConfig(args)
+		if err != nil {
+			return err
+		}
+		layout = append(layout, iso9660util.Entry{
+			Path:   "network-config",
+			Reader: bytes.NewReader(networkConfig),
+		})
+	}
+
 	if guestAgentBinary, err := GuestAgentBinary(y.Arch); err != nil {
 		return err
 	} else {
@@ -93,25 +188,24 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 	}
 
 	if args.Containerd.System || args.Containerd.User {
-		var nftgzBase string
-		switch y.Arch {
-		case limayaml.X8664:
-			nftgzBase = fmt.Sprintf("nerdctl-full-%s-linux-amd64.tar.gz", NerdctlVersion)
-		case limayaml.AARCH64:
-			nftgzBase = fmt.Sprintf("nerdctl-full-%s-linux-arm64.tar.gz", NerdctlVersion)
-		default:
-			return errors.Errorf("unexpected arch %q", y.Arch)
+		nftgzURL, err := NerdctlArchiveURL(y.Arch)
+		if err != nil {
+			return err
+		}
+		nftgzDigest, err := nerdctlArchiveDigest(y.Arch)
+		if err != nil {
+			return err
 		}
 		td, err := ioutil.TempDir("", "lima-download-nerdctl")
 		if err != nil {
 			return err
 		}
 		defer os.RemoveAll(td)
+		nftgzBase := path.Base(nftgzURL)
 		nftgzLocal := filepath.Join(td, nftgzBase)
-		nftgzURL := fmt.Sprintf("https://github.com/containerd/nerdctl/releases/download/v%s/%s",
-			NerdctlVersion, nftgzBase)
 		logrus.Infof("Downloading %q", nftgzURL)
-		res, err := downloader.Download(nftgzLocal, nftgzURL, downloader.WithCache())
+		opts := append([]downloader.Opt{downloader.WithCache(), downloader.WithExpectedDigest(nftgzDigest)}, downloadOpts...)
+		res, err := downloader.Download(nftgzLocal, nftgzURL, opts...)
 		if err != nil {
 			return errors.Wrapf(err, "failed to download %q", nftgzURL)
 		}
@@ -123,7 +217,6 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 		default:
 			logrus.Warnf("Unexpected result from downloader.Download(): %+v", res)
 		}
-		// TODO: verify sha256
 		nftgzR, err := os.Open(nftgzLocal)
 		if err != nil {
 			return err
@@ -139,6 +232,152 @@ func GenerateISO9660(isoPath, name string, y *limayaml.LimaYAML) error {
 	return iso9660util.Write(isoPath, "cidata", layout)
 }
 
+// guestProxy returns the proxy of the guest.
+// The loopback addresses of the host are rewritten to qemu.SlirpGateway.
+func guestProxy(p limayaml.Proxy) (Proxy, error) {
+	var (
+		res Proxy
+		err error
+	)
+	if p.HTTP != "" {
+		if res.HTTP, err = guestProxyURL(p.HTTP); err != nil {
+			return res, err
+		}
+	}
+	if p.HTTPS != "" {
+		if res.HTTPS, err = guestProxyURL(p.HTTPS); err != nil {
+			return res, err
+		}
+	}
+	if res.HTTP != "" || res.HTTPS != "" {
+		// The loopback addresses of the guest never go through the proxy
+		noProxy := append([]string(nil), p.NoProxy...)
+		for _, f := range []string{"localhost", "127.0.0.1", "::1"} {
+			if !contains(noProxy, f) {
+				noProxy = append(noProxy, f)
+			}
+		}
+		res.NoProxy = strings.Join(noProxy, ",")
+	}
+	return res, nil
+}
+
+// proxyFromEnv fills the empty fields of p from the proxy environment variables of the host.
+// The invalid values are skipped with warnings, as they are not written in lima.yaml.
+func proxyFromEnv(p limayaml.Proxy, getenv func(string) string) limayaml.Proxy {
+	lookup := func(keys ...string) (string, string) {
+		for _, k := range keys {
+			if v := getenv(k); v != "" {
+				return k, v
+			}
+		}
+		return "", ""
+	}
+	if p.HTTP == "" {
+		if k, v := lookup("http_proxy", "HTTP_PROXY"); v != "" {
+			if err := limayaml.ValidateProxyURL(v); err != nil {
+				logrus.WithError(err).Warnf("Ignoring $%s", k)
+			} else {
+				p.HTTP = v
+			}
+		}
+	}
+	if p.HTTPS == "" {
+		if k, v := lookup("https_proxy", "HTTPS_PROXY"); v != "" {
+			if err := limayaml.ValidateProxyURL(v); err != nil {
+				logrus.WithError(err).Warnf("Ignoring $%s", k)
+			} else {
+				p.HTTPS = v
+			}
+		}
+	}
+	if len(p.NoProxy) == 0 {
+		k, v := lookup("no_proxy", "NO_PROXY")
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "" {
+				continue
+			}
+			if err := limayaml.ValidateNoProxy(f); err != nil {
+				logrus.WithError(err).Warnf("Ignoring an entry of $%s", k)
+				continue
+			}
+			p.NoProxy = append(p.NoProxy, f)
+		}
+	}
+	return p
+}
+
+func guestProxyURL(s string) (string, error) {
+	u, err := limayaml.ParseProxyURL(s)
+	if err != nil {
+		return "", err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(qemu.SlirpGateway, port)
+		} else {
+			u.Host = qemu.SlirpGateway
+		}
+	}
+	return u.String(), nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, f := range ss {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Hosts returns the entries of /etc/hosts of the guest: the static entries (`dns.hosts`),
+// and the instances on the networks of type "lima".
+func Hosts(y *limayaml.LimaYAML, limaNetworks map[string]LimaNetwork) []Host {
+	return append(staticHosts(y.DNS.Hosts), limaNetworkHosts(limaNetworks)...)
+}
+
+// staticHosts returns the hosts entries of the `dns.hosts` field, sorted by the names.
+func staticHosts(m map[string]net.IP) []Host {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var hosts []Host
+	for _, name := range names {
+		hosts = append(hosts, Host{IP: m[name].String(), Names: []string{name}})
+	}
+	return hosts
+}
+
+// limaNetworkHosts returns the hosts entries of the instances on the networks of type "lima".
+// The instances are resolvable as both "NAME" and "lima-NAME" (the hostname).
+func limaNetworkHosts(limaNetworks map[string]LimaNetwork) []Host {
+	var networkNames []string
+	for networkName := range limaNetworks {
+		networkNames = append(networkNames, networkName)
+	}
+	sort.Strings(networkNames)
+	var hosts []Host
+	for _, networkName := range networkNames {
+		nw := limaNetworks[networkName]
+		var instNames []string
+		for instName := range nw.Addresses {
+			instNames = append(instNames, instName)
+		}
+		sort.Strings(instNames)
+		for _, instName := range instNames {
+			hosts = append(hosts, Host{
+				IP:    nw.Addresses[instName],
+				Names: []string{instName, "lima-" + instName},
+			})
+		}
+	}
+	return hosts
+}
+
 func GuestAgentBinary(arch string) (io.ReadCloser, error) {
 	if arch == "" {
 		return nil, errors.New("arch must be set")
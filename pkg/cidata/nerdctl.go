@@ -0,0 +1,51 @@
+package cidata
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/lima/pkg/downloader"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/pkg/errors"
+)
+
+const NerdctlVersion = "0.8.3"
+
+// The digests of the nerdctl-full archives, from the SHA256SUMS file of the release.
+// Update them together with NerdctlVersion.
+const (
+	NerdctlFullDigestAMD64 = ""
+	NerdctlFullDigestARM64 = ""
+)
+
+const nerdctlReleaseURL = "https://github.com/containerd/nerdctl/releases/download/v" + NerdctlVersion
+
+// NerdctlArchiveURL returns the URL of the nerdctl-full archive for the arch.
+func NerdctlArchiveURL(arch limayaml.Arch) (string, error) {
+	var goarch string
+	switch arch {
+	case limayaml.X8664:
+		goarch = "amd64"
+	case limayaml.AARCH64:
+		goarch = "arm64"
+	default:
+		return "", errors.Errorf("unexpected arch %q", arch)
+	}
+	return fmt.Sprintf("%s/nerdctl-full-%s-linux-%s.tar.gz", nerdctlReleaseURL, NerdctlVersion, goarch), nil
+}
+
+// nerdctlArchiveDigest returns the pinned digest of the nerdctl-full archive for the arch.
+func nerdctlArchiveDigest(arch limayaml.Arch) (string, error) {
+	var digest string
+	switch arch {
+	case limayaml.X8664:
+		digest = NerdctlFullDigestAMD64
+	case limayaml.AARCH64:
+		digest = NerdctlFullDigestARM64
+	default:
+		return "", errors.Errorf("unexpected arch %q", arch)
+	}
+	if err := downloader.ValidateDigest(digest); err != nil {
+		return "", errors.Wrapf(err, "invalid digest of nerdctl-full %s for %q", NerdctlVersion, arch)
+	}
+	return digest, nil
+}
@@ -0,0 +1,18 @@
+package cidata
+
+import (
+	"testing"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestNerdctlArchiveDigest(t *testing.T) {
+	for _, arch := range []limayaml.Arch{limayaml.X8664, limayaml.AARCH64} {
+		digest, err := nerdctlArchiveDigest(arch)
+		assert.NilError(t, err, "NerdctlFullDigest* must be filled from the SHA256SUMS of nerdctl %s", NerdctlVersion)
+		assert.Assert(t, digest != "")
+	}
+	_, err := nerdctlArchiveDigest("riscv64")
+	assert.ErrorContains(t, err, "unexpected arch")
+}
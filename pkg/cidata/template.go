@@ -2,8 +2,11 @@ package cidata
 
 import (
 	_ "embed"
+	"net"
 	"path/filepath"
+	"strings"
 
+	"github.com/AkihiroSuda/lima/pkg/envutil"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
 
 	"github.com/AkihiroSuda/lima/pkg/templateutil"
@@ -16,20 +19,105 @@ var (
 	userDataTemplate string
 	//go:embed meta-data.TEMPLATE
 	metaDataTemplate string
+	//go:embed network-config.TEMPLATE
+	networkConfigTemplate string
 )
 
 type Containerd struct {
 	System bool
 	User   bool
 }
+type Mount struct {
+	Tag        string // 9p/virtiofs tag, empty for reverse-sshfs
+	MountPoint string // abs path, accessible by the User
+	Type       limayaml.MountType
+	Writable   bool
+}
+type Disk struct {
+	Name   string // mounted on /mnt/lima-<Name>
+	Serial string // virtio-blk serial
+}
+type Network struct {
+	MACAddress string
+	Address    string // static address in the CIDR notation, empty for DHCP
+	Metric     int    // route metric, 0 for the default
+	DNS        bool   // use TemplateArgs.DNSServers and TemplateArgs.DNSSearch instead of the DNS configuration of DHCP
+}
+type Host struct {
+	IP    string
+	Names []string
+}
+
+// Proxy is the proxy of the guest, rewritten from the `proxy` field for the guest.
+type Proxy struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string // comma-separated
+}
+
+// Provision is a provisioning script (the `provision` field).
+type Provision struct {
+	Name    string // file name under /var/lib/lima-guestagent/provision.d
+	Mode    limayaml.ProvisionMode
+	After   []string
+	RunOnce bool
+	Script  string
+}
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Env returns the environment variables of the proxy, in both lower and upper cases.
+func (p Proxy) Env() []EnvVar {
+	var env []EnvVar
+	for _, f := range []struct{ name, value string }{
+		{"http_proxy", p.HTTP},
+		{"https_proxy", p.HTTPS},
+		{"no_proxy", p.NoProxy},
+	} {
+		if f.value != "" {
+			env = append(env, EnvVar{f.name, f.value}, EnvVar{strings.ToUpper(f.name), f.value})
+		}
+	}
+	return env
+}
+
+// Environment returns the environment variables written to /etc/environment.
+// The variables in Env take precedence over the ones of Proxy.
+func (args TemplateArgs) Environment() []EnvVar {
+	var env []EnvVar
+	names := make(map[string]bool)
+	for _, f := range args.Env {
+		names[f.Name] = true
+	}
+	for _, f := range args.Proxy.Env() {
+		if !names[f.Name] {
+			env = append(env, f)
+		}
+	}
+	return append(env, args.Env...)
+}
+
 type TemplateArgs struct {
 	Name       string // instance name
 	User       string // user name
 	UID        int
 	SSHPubKeys []string
-	Mounts     []string // abs path, accessible by the User
-	Provision  []limayaml.Provision
-	Containerd Containerd
+	Mounts     []Mount
+	Disks      []Disk
+	Networks   []Network // empty for the default network configuration of cloud-init
+	Hosts      []Host    // appended to /etc/hosts
+	DNSServers []string  // empty for the DNS server of DHCP
+	DNSSearch  []string
+	// HostResolverPort is the port of the DNS forwarder of the host agent, 0 when it is disabled.
+	// The queries to 192.168.5.3:53 are redirected to 192.168.5.2:HostResolverPort.
+	HostResolverPort int
+	Proxy            Proxy
+	Env              []EnvVar    // the `env` field, sorted by the names
+	Provision        []Provision // in the execution order, see limayaml.ProvisionOrder
+	Containerd       Containerd
 }
 
 func ValidateTemplateArgs(args TemplateArgs) error {
@@ -48,14 +136,88 @@ func ValidateTemplateArgs(args TemplateArgs) error {
 	if len(args.SSHPubKeys) == 0 {
 		return errors.New("field SSHPubKeys must be set")
 	}
+	for i, f := range args.Disks {
+		if err := identifiers.Validate(f.Name); err != nil {
+			return errors.Wrapf(err, "field disks[%d] has an invalid name", i)
+		}
+		if f.Serial == "" {
+			return errors.Errorf("field disks[%d] must have the serial", i)
+		}
+	}
+	for i, f := range args.Networks {
+		if _, err := net.ParseMAC(f.MACAddress); err != nil {
+			return errors.Wrapf(err, "field networks[%d] has an invalid MAC address", i)
+		}
+		if f.Address != "" {
+			if _, _, err := net.ParseCIDR(f.Address); err != nil {
+				return errors.Wrapf(err, "field networks[%d] has an invalid address", i)
+			}
+		}
+	}
+	for i, f := range args.DNSServers {
+		if net.ParseIP(f) == nil {
+			return errors.Errorf("field DNSServers[%d] has an invalid IP %q", i, f)
+		}
+	}
+	if args.HostResolverPort < 0 || args.HostResolverPort > 65535 {
+		return errors.Errorf("field HostResolverPort has an invalid port %d", args.HostResolverPort)
+	}
+	for i, f := range args.Hosts {
+		if net.ParseIP(f.IP) == nil {
+			return errors.Errorf("field hosts[%d] has an invalid IP %q", i, f.IP)
+		}
+		for _, name := range f.Names {
+			if name == "" || strings.ContainsAny(name, " \t\r\n#") {
+				return errors.Errorf("field hosts[%d] has an invalid name %q", i, name)
+			}
+		}
+	}
+	for _, f := range args.Proxy.Env() {
+		if strings.ContainsAny(f.Value, " \t\r\n\"'`$\\") {
+			return errors.Errorf("field Proxy has an invalid value %q", f.Value)
+		}
+	}
+	for _, f := range args.Env {
+		if err := envutil.ValidateName(f.Name); err != nil {
+			return errors.Wrap(err, "field Env has an invalid name")
+		}
+		if strings.ContainsAny(f.Value, "\r\n\"`$\\") {
+			return errors.Errorf("field Env has an invalid value %q", f.Value)
+		}
+	}
+	names := make(map[string]bool, len(args.Provision))
+	for i, f := range args.Provision {
+		if f.Name == "" || f.Name == "." || f.Name == ".." || strings.ContainsAny(f.Name, "/ \t\r\n\"'`$\\") {
+			return errors.Errorf("field provision[%d] has an invalid name %q", i, f.Name)
+		}
+		for _, after := range f.After {
+			if !names[after] {
+				return errors.Errorf("field provision[%d] must be after %q, but %q is not executed before", i, after, after)
+			}
+		}
+		names[f.Name] = true
+	}
 	for i, f := range args.Mounts {
-		if !filepath.IsAbs(f) {
-			return errors.Errorf("field mounts[%d] must be absolute, got %q", i, f)
+		if !filepath.IsAbs(f.MountPoint) {
+			return errors.Errorf("field mounts[%d] must be absolute, got %q", i, f.MountPoint)
+		}
+		if f.Type != limayaml.ReverseSSHFS && f.Tag == "" {
+			return errors.Errorf("field mounts[%d] must have the tag", i)
 		}
 	}
 	return nil
 }
 
+// HasMountType returns true if any of the mounts is of the type t.
+func (args TemplateArgs) HasMountType(t limayaml.MountType) bool {
+	for _, f := range args.Mounts {
+		if f.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
 func GenerateUserData(args TemplateArgs) ([]byte, error) {
 	if err := ValidateTemplateArgs(args); err != nil {
 		return nil, err
@@ -69,3 +231,10 @@ func GenerateMetaData(args TemplateArgs) ([]byte, error) {
 	}
 	return templateutil.Execute(metaDataTemplate, args)
 }
+
+func GenerateNetworkConfig(args TemplateArgs) ([]byte, error) {
+	if err := ValidateTemplateArgs(args); err != nil {
+		return nil, err
+	}
+	return templateutil.Execute(networkConfigTemplate, args)
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheEntry is an entry of the download cache.
+type CacheEntry struct {
+	Key      string    `json:"key"` // SHA256 of the URL
+	Dir      string    `json:"dir"` // "/Users/foo/Library/Caches/lima/download/by-url-sha256/<SHA256_OF_URL>"
+	URL      string    `json:"url,omitempty"`
+	Size     int64     `json:"size"`     // including the partial download
+	LastUsed time.Time `json:"lastUsed"` // the modification time of the data
+	Complete bool      `json:"complete"` // false if the download is incomplete
+}
+
+// CacheKey returns the key of the cache entry for the remote URL.
+func CacheKey(remote string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(remote)))
+}
+
+func cacheEntriesDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "download", "by-url-sha256")
+}
+
+func cacheEntryDir(cacheDir, remote string) string {
+	return filepath.Join(cacheEntriesDir(cacheDir), CacheKey(remote))
+}
+
+// touchCacheData updates the modification time of the cached data, to record the last-used time.
+func touchCacheData(shadData string) {
+	now := time.Now()
+	if err := os.Chtimes(shadData, now, now); err != nil {
+		logrus.WithError(err).Debugf("failed to update the modification time of %q", shadData)
+	}
+}
+
+// CacheEntries returns the entries in the cache dir, sorted by the last-used time (the most recent one first).
+func CacheEntries(cacheDir string) ([]CacheEntry, error) {
+	dir := cacheEntriesDir(cacheDir)
+	dirEnts, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []CacheEntry
+	for _, dirEnt := range dirEnts {
+		if !dirEnt.IsDir() {
+			continue
+		}
+		e, err := inspectCacheEntry(filepath.Join(dir, dirEnt.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *e)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].LastUsed.After(res[j].LastUsed)
+	})
+	return res, nil
+}
+
+func inspectCacheEntry(shad string) (*CacheEntry, error) {
+	e := &CacheEntry{
+		Key: filepath.Base(shad),
+		Dir: shad,
+	}
+	if b, err := os.ReadFile(filepath.Join(shad, "url")); err == nil {
+		e.URL = strings.TrimSpace(string(b))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	files, err := os.ReadDir(shad)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		fi, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		switch f.Name() {
+		case "data":
+			e.Complete = true
+			e.Size += fi.Size()
+			e.LastUsed = fi.ModTime()
+		case "data.tmp":
+			e.Size += fi.Size()
+			if e.LastUsed.IsZero() {
+				e.LastUsed = fi.ModTime()
+			}
+		}
+	}
+	return e, nil
+}
+
+// RemoveCacheEntry removes the cache entry.
+func RemoveCacheEntry(e CacheEntry) error {
+	logrus.Debugf("removing the cache entry %q (%q)", e.Dir, e.URL)
+	return os.RemoveAll(e.Dir)
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// digestAlgorithms maps the supported algorithms to the hash functions.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// splitDigest splits "ALGORITHM:HEX" into ALGORITHM and HEX, and validates them.
+func splitDigest(digest string) (string, string, error) {
+	split := strings.SplitN(digest, ":", 2)
+	if len(split) != 2 {
+		return "", "", errors.Errorf("digest %q must be in the form of \"ALGORITHM:HEX\"", digest)
+	}
+	algo, encoded := split[0], split[1]
+	newHash, ok := digestAlgorithms[algo]
+	if !ok {
+		return "", "", errors.Errorf("digest %q has an unsupported algorithm %q (must be \"sha256\" or \"sha512\")", digest, algo)
+	}
+	if expectedLen := hex.EncodedLen(newHash().Size()); len(encoded) != expectedLen {
+		return "", "", errors.Errorf("digest %q must have %d hex characters, got %d", digest, expectedLen, len(encoded))
+	}
+	if _, err := hex.DecodeString(encoded); err != nil || strings.ToLower(encoded) != encoded {
+		return "", "", errors.Errorf("digest %q must be lower-case hex", digest)
+	}
+	return algo, encoded, nil
+}
+
+// ValidateDigest validates the format of the digest, e.g., "sha256:e3b0c442...".
+func ValidateDigest(digest string) error {
+	_, _, err := splitDigest(digest)
+	return err
+}
+
+// verifyDigest verifies that the file has the expected digest.
+func verifyDigest(path, expectedDigest string) error {
+	algo, expected, err := splitDigest(expectedDigest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := digestAlgorithms[algo]()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != expected {
+		return errors.Errorf("expected digest %q, got \"%s:%s\"", expectedDigest, algo, actual)
+	}
+	return nil
+}
+
+// writeDigestFile writes the verified digest as "<ALGORITHM>.digest" in the cache dir shad.
+func writeDigestFile(shad, verifiedDigest string) error {
+	algo, encoded, err := splitDigest(verifiedDigest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(shad, algo+".digest"), []byte(encoded), 0644)
+}
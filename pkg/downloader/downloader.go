@@ -1,10 +1,7 @@
 package downloader
 
 import (
-	"crypto/sha256"
-	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -29,19 +26,29 @@ type Result struct {
 }
 
 type options struct {
-	cacheDir string // default: empty (disables caching)
+	cacheDir       string       // default: empty (disables caching)
+	expectedDigest string       // default: empty (disables verification)
+	progress       ProgressFunc // default: nil
 }
 
 type Opt func(*options) error
 
-// WithCache enables caching using filepath.Join(os.UserCacheDir(), "lima") as the cache dir.
+// DefaultCacheDir returns filepath.Join(os.UserCacheDir(), "lima").
+func DefaultCacheDir() (string, error) {
+	ucd, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ucd, "lima"), nil
+}
+
+// WithCache enables caching using DefaultCacheDir as the cache dir.
 func WithCache() Opt {
 	return func(o *options) error {
-		ucd, err := os.UserCacheDir()
+		cacheDir, err := DefaultCacheDir()
 		if err != nil {
 			return err
 		}
-		cacheDir := filepath.Join(ucd, "lima")
 		return WithCacheDir(cacheDir)(o)
 	}
 }
@@ -55,6 +62,37 @@ func WithCacheDir(cacheDir string) Opt {
 	}
 }
 
+// WithExpectedDigest is used to verify the downloaded file (and the cached file).
+// The digest is in the form of "ALGORITHM:HEX", e.g., "sha256:e3b0c442...".
+// Supported algorithms are "sha256" and "sha512".
+// Empty value disables verification.
+func WithExpectedDigest(expectedDigest string) Opt {
+	return func(o *options) error {
+		if expectedDigest != "" {
+			if err := ValidateDigest(expectedDigest); err != nil {
+				return err
+			}
+		}
+		o.expectedDigest = expectedDigest
+		return nil
+	}
+}
+
+// WithProgress sets the callback for reporting the progress of HTTP downloads.
+func WithProgress(f ProgressFunc) Opt {
+	return func(o *options) error {
+		o.progress = f
+		return nil
+	}
+}
+
+// Download downloads the remote resource into the local path.
+//
+// HTTP downloads honor the proxy environment variables (HTTP_PROXY, HTTPS_PROXY, and NO_PROXY).
+// Interrupted HTTP downloads are resumed on the next call, when the server supports range requests.
+//
+// When the cache is enabled, the cached file is revalidated with the ETag or Last-Modified header of the remote,
+// unless WithExpectedDigest is specified.
 func Download(local, remote string, opts ...Opt) (*Result, error) {
 	var o options
 	for _, f := range opts {
@@ -85,6 +123,12 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 		if err := copyLocal(localPath, remote); err != nil {
 			return nil, err
 		}
+		if o.expectedDigest != "" {
+			if err := verifyDigest(localPath, o.expectedDigest); err != nil {
+				_ = os.RemoveAll(localPath)
+				return nil, errors.Wrapf(err, "failed to verify %q", remote)
+			}
+		}
 		res := &Result{
 			Status: StatusDownloaded,
 		}
@@ -92,7 +136,7 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 	}
 
 	if o.cacheDir == "" {
-		if err := downloadHTTP(localPath, remote); err != nil {
+		if _, err := downloadHTTP(localPath, remote, o.expectedDigest, o.progress); err != nil {
 			return nil, err
 		}
 		res := &Result{
@@ -101,22 +145,38 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 		return res, nil
 	}
 
-	shad := filepath.Join(o.cacheDir, "download", "by-url-sha256", fmt.Sprintf("%x", sha256.Sum256([]byte(remote))))
+	shad := cacheEntryDir(o.cacheDir, remote)
 	shadData := filepath.Join(shad, "data")
+	shadValidator := filepath.Join(shad, "validator")
 	if _, err := os.Stat(shadData); err == nil {
 		logrus.Debugf("file %q is cached as %q", localPath, shadData)
+		if o.expectedDigest != "" {
+			if err := verifyDigest(shadData, o.expectedDigest); err != nil {
+				return nil, errors.Wrapf(err, "the cached file %q is corrupted or outdated (remove %q to download the file again)", shadData, shad)
+			}
+			if err := writeDigestFile(shad, o.expectedDigest); err != nil {
+				return nil, err
+			}
+		} else if isCacheOutdated(remote, shadValidator) {
+			logrus.Infof("The cached file %q is outdated, downloading %q again", shadData, remote)
+			return downloadToCache(localPath, remote, shad, o)
+		}
 		if err := copyLocal(localPath, shadData); err != nil {
 			return nil, err
 		}
+		touchCacheData(shadData)
 		res := &Result{
 			Status:    StatusUsedCache,
 			CachePath: shadData,
 		}
 		return res, nil
 	}
-	if err := os.RemoveAll(shad); err != nil {
-		return nil, err
-	}
+	return downloadToCache(localPath, remote, shad, o)
+}
+
+// downloadToCache downloads remote into the cache dir shad, and copies the data to localPath.
+// The partial download in shad is resumed.
+func downloadToCache(localPath, remote, shad string, o options) (*Result, error) {
 	if err := os.MkdirAll(shad, 0700); err != nil {
 		return nil, err
 	}
@@ -124,7 +184,32 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 	if err := os.WriteFile(shadURL, []byte(remote), 0644); err != nil {
 		return nil, err
 	}
-	if err := downloadHTTP(shadData, remote); err != nil {
+	shadData := filepath.Join(shad, "data")
+	validator, err := downloadHTTP(shadData, remote, o.expectedDigest, o.progress)
+	if err != nil {
+		return nil, err
+	}
+	// The digest files of the previous data are no longer valid
+	oldDigestFiles, err := filepath.Glob(filepath.Join(shad, "*.digest"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range oldDigestFiles {
+		if err := os.Remove(f); err != nil {
+			return nil, err
+		}
+	}
+	if o.expectedDigest != "" {
+		if err := writeDigestFile(shad, o.expectedDigest); err != nil {
+			return nil, err
+		}
+	}
+	shadValidator := filepath.Join(shad, "validator")
+	if validator != "" {
+		if err := os.WriteFile(shadValidator, []byte(validator), 0644); err != nil {
+			return nil, err
+		}
+	} else if err := os.RemoveAll(shadValidator); err != nil {
 		return nil, err
 	}
 	if err := copyLocal(localPath, shadData); err != nil {
@@ -167,25 +252,3 @@ func copyLocal(dst, src string) error {
 	}
 	return fs.CopyFile(dstPath, srcPath)
 }
-
-func downloadHTTP(localPath, url string) error {
-	logrus.Debugf("downloading %q into %q", url, localPath)
-	localPathTmp := localPath + ".tmp"
-	if err := os.RemoveAll(localPathTmp); err != nil {
-		return err
-	}
-	// use curl for printing progress
-	cmd := exec.Command("curl", "-fSL", "-o", localPathTmp, url)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "failed to run %v", cmd.Args)
-	}
-	if err := os.RemoveAll(localPath); err != nil {
-		return err
-	}
-	if err := os.Rename(localPathTmp, localPath); err != nil {
-		return err
-	}
-	return nil
-}
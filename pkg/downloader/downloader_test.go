@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateDigest(t *testing.T) {
+	assert.NilError(t, ValidateDigest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	assert.NilError(t, ValidateDigest("sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"))
+	assert.ErrorContains(t, ValidateDigest("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"), "ALGORITHM:HEX")
+	assert.ErrorContains(t, ValidateDigest("md5:d41d8cd98f00b204e9800998ecf8427e"), "unsupported algorithm")
+	assert.ErrorContains(t, ValidateDigest("sha256:e3b0c442"), "64 hex characters")
+	assert.ErrorContains(t, ValidateDigest("sha256:E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"), "lower-case hex")
+}
+
+func TestDownloadWithExpectedDigest(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("hello")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	wrongDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("world")))
+
+	t.Run("local", func(t *testing.T) {
+		src := filepath.Join(dir, "local-src")
+		assert.NilError(t, os.WriteFile(src, content, 0644))
+		_, err := Download(filepath.Join(dir, "local-dst-ok"), src, WithExpectedDigest(digest))
+		assert.NilError(t, err)
+
+		dst := filepath.Join(dir, "local-dst-ng")
+		_, err = Download(dst, src, WithExpectedDigest(wrongDigest))
+		assert.ErrorContains(t, err, "expected digest")
+		_, err = os.Stat(dst)
+		assert.Assert(t, os.IsNotExist(err))
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		cacheDir := filepath.Join(dir, "cache")
+		remote := "https://example.com/image.img"
+		shad := filepath.Join(cacheDir, "download", "by-url-sha256", fmt.Sprintf("%x", sha256.Sum256([]byte(remote))))
+		assert.NilError(t, os.MkdirAll(shad, 0700))
+		assert.NilError(t, os.WriteFile(filepath.Join(shad, "url"), []byte(remote), 0644))
+		assert.NilError(t, os.WriteFile(filepath.Join(shad, "data"), content, 0644))
+
+		_, err := Download(filepath.Join(dir, "cache-dst-ng"), remote, WithCacheDir(cacheDir), WithExpectedDigest(wrongDigest))
+		assert.ErrorContains(t, err, "expected digest")
+
+		res, err := Download(filepath.Join(dir, "cache-dst-ok"), remote, WithCacheDir(cacheDir), WithExpectedDigest(digest))
+		assert.NilError(t, err)
+		assert.Equal(t, StatusUsedCache, res.Status)
+		b, err := os.ReadFile(filepath.Join(shad, "sha256.digest"))
+		assert.NilError(t, err)
+		assert.Equal(t, digest, "sha256:"+string(b))
+	})
+}
+
+// newTestServer returns a server that serves *content with *etag, and records the "Range" headers.
+func newTestServer(t *testing.T, content *[]byte, etag *string, ranges *[]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", *etag)
+		http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(*content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDownloadHTTPResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	etag := `"v1"`
+	var ranges []string
+	srv := newTestServer(t, &content, &etag, &ranges)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+
+	// Simulate an interrupted download
+	assert.NilError(t, os.WriteFile(local+".tmp", content[:1234], 0644))
+	assert.NilError(t, os.WriteFile(local+".tmp.validator", []byte(etag), 0644))
+	var progress []Progress
+	_, err := Download(local, srv.URL+"/data", WithProgress(func(p Progress) { progress = append(progress, p) }))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bytes=1234-"}, ranges)
+	b, err := os.ReadFile(local)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, content, b)
+	_, err = os.Stat(local + ".tmp.validator")
+	assert.Assert(t, os.IsNotExist(err))
+	assert.Equal(t, int64(1234), progress[0].Written)
+	assert.Equal(t, Progress{Written: int64(len(content)), Total: int64(len(content)), Done: true}, progress[len(progress)-1])
+
+	// The partial download is discarded when the remote was modified
+	ranges = nil
+	local2 := filepath.Join(dir, "local2")
+	assert.NilError(t, os.WriteFile(local2+".tmp", []byte("stale"), 0644))
+	assert.NilError(t, os.WriteFile(local2+".tmp.validator", []byte(`"v0"`), 0644))
+	_, err = Download(local2, srv.URL+"/data")
+	assert.NilError(t, err)
+	b, err = os.ReadFile(local2)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, content, b)
+}
+
+func TestDownloadHTTPCacheRevalidation(t *testing.T) {
+	content := []byte("v1")
+	etag := `"v1"`
+	var ranges []string
+	srv := newTestServer(t, &content, &etag, &ranges)
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	remote := srv.URL + "/data"
+
+	res, err := Download(filepath.Join(dir, "a"), remote, WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, StatusDownloaded, res.Status)
+
+	res, err = Download(filepath.Join(dir, "b"), remote, WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, StatusUsedCache, res.Status)
+
+	content = []byte("v2")
+	etag = `"v2"`
+	res, err = Download(filepath.Join(dir, "c"), remote, WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, StatusDownloaded, res.Status)
+	b, err := os.ReadFile(filepath.Join(dir, "c"))
+	assert.NilError(t, err)
+	assert.Equal(t, "v2", string(b))
+
+	// The remote is not checked when the digest is specified
+	srv.Close()
+	res, err = Download(filepath.Join(dir, "d"), remote, WithCacheDir(cacheDir),
+		WithExpectedDigest(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("v2")))))
+	assert.NilError(t, err)
+	assert.Equal(t, StatusUsedCache, res.Status)
+}
+
+func TestCacheEntries(t *testing.T) {
+	content := []byte("hello")
+	etag := `"hello"`
+	var ranges []string
+	srv := newTestServer(t, &content, &etag, &ranges)
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+
+	entries, err := CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	remotes := []string{srv.URL + "/a", srv.URL + "/b"}
+	for i, remote := range remotes {
+		_, err := Download(filepath.Join(dir, fmt.Sprintf("dst-%d", i)), remote, WithCacheDir(cacheDir))
+		assert.NilError(t, err)
+	}
+	// Mark remotes[0] as the most recently used one
+	old := time.Now().Add(-time.Hour)
+	assert.NilError(t, os.Chtimes(filepath.Join(cacheEntryDir(cacheDir, remotes[0]), "data"), old, old))
+	assert.NilError(t, os.Chtimes(filepath.Join(cacheEntryDir(cacheDir, remotes[1]), "data"), old, old))
+	res, err := Download(filepath.Join(dir, "dst-again"), remotes[0], WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, StatusUsedCache, res.Status)
+
+	entries, err = CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, remotes[0], entries[0].URL)
+	assert.Equal(t, CacheKey(remotes[0]), entries[0].Key)
+	assert.Equal(t, int64(len(content)), entries[0].Size)
+	assert.Assert(t, entries[0].Complete)
+	assert.Assert(t, entries[0].LastUsed.After(old))
+	assert.Equal(t, remotes[1], entries[1].URL)
+
+	assert.NilError(t, RemoveCacheEntry(entries[1]))
+	entries, err = CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
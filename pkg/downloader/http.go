@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// httpClient honors the proxy environment variables, via http.DefaultTransport.
+var httpClient = http.DefaultClient
+
+// revalidationTimeout is the timeout for checking whether the cached file is outdated.
+const revalidationTimeout = 30 * time.Second
+
+func successful(resp *http.Response) error {
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected HTTP status %q", resp.Status)
+	}
+	return nil
+}
+
+// validatorOf returns the validator of the response that can be used for the "If-Range" header.
+// The strong ETag is preferred over Last-Modified. Weak ETags cannot be used for "If-Range".
+func validatorOf(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// isCacheOutdated returns true when the validator of remote differs from the validator
+// stored in the file validatorPath.
+// isCacheOutdated returns false when the validator is not stored, or when the remote is unreachable.
+func isCacheOutdated(remote, validatorPath string) bool {
+	b, err := os.ReadFile(validatorPath)
+	if err != nil || len(b) == 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), revalidationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", remote, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to check whether the cache of %q is outdated, using the cache", remote)
+		return false
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		logrus.WithError(err).Warnf("failed to check whether the cache of %q is outdated, using the cache", remote)
+		return false
+	}
+	validator := validatorOf(resp)
+	return validator != "" && validator != string(b)
+}
+
+// downloadHTTP downloads url into localPath, and returns the validator (see validatorOf) of the downloaded file.
+//
+// The file is downloaded as localPath+".tmp", and renamed to localPath on completion.
+// An interrupted download is resumed with a range request, when localPath+".tmp.validator"
+// exists and still matches the remote.
+//
+// When expectedDigest is not empty, localPath is created only when the digest matches.
+func downloadHTTP(localPath, url, expectedDigest string, progress ProgressFunc) (string, error) {
+	logrus.Debugf("downloading %q into %q", url, localPath)
+	localPathTmp := localPath + ".tmp"
+	localPathTmpValidator := localPathTmp + ".validator"
+	validator, err := downloadHTTPTmp(localPathTmp, localPathTmpValidator, url, progress, true)
+	if err != nil {
+		return "", err
+	}
+	if expectedDigest != "" {
+		if err := verifyDigest(localPathTmp, expectedDigest); err != nil {
+			_ = os.RemoveAll(localPathTmp)
+			_ = os.RemoveAll(localPathTmpValidator)
+			return "", errors.Wrapf(err, "failed to verify the file downloaded from %q", url)
+		}
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		return "", err
+	}
+	if err := os.Rename(localPathTmp, localPath); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(localPathTmpValidator); err != nil {
+		return "", err
+	}
+	return validator, nil
+}
+
+func downloadHTTPTmp(localPathTmp, localPathTmpValidator, url string, progress ProgressFunc, retryOnRangeError bool) (string, error) {
+	var (
+		offset     int64
+		validator  string
+		tmpFlags   = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		ifRangeVal string
+	)
+	if st, err := os.Stat(localPathTmp); err == nil && st.Size() > 0 {
+		if b, err := os.ReadFile(localPathTmpValidator); err == nil && len(b) > 0 {
+			offset = st.Size()
+			ifRangeVal = string(b)
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", ifRangeVal)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if expected := fmt.Sprintf("bytes %d-", offset); !strings.HasPrefix(resp.Header.Get("Content-Range"), expected) {
+			return "", errors.Errorf("expected Content-Range to start with %q, got %q", expected, resp.Header.Get("Content-Range"))
+		}
+		logrus.Infof("Resuming the download of %q from %d bytes", url, offset)
+		tmpFlags = os.O_WRONLY | os.O_APPEND
+		validator = ifRangeVal
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is broken (e.g., larger than the remote), so we start over
+		if err := os.RemoveAll(localPathTmp); err != nil {
+			return "", err
+		}
+		if !retryOnRangeError {
+			return "", errors.Errorf("unexpected HTTP status %d for %q", resp.StatusCode, url)
+		}
+		resp.Body.Close()
+		return downloadHTTPTmp(localPathTmp, localPathTmpValidator, url, progress, false)
+	default:
+		if err := successful(resp); err != nil {
+			return "", errors.Wrapf(err, "failed to download %q", url)
+		}
+		offset = 0
+		validator = validatorOf(resp)
+		// Record the validator before writing the data, so that the download can be resumed
+		if validator != "" {
+			if err := os.WriteFile(localPathTmpValidator, []byte(validator), 0644); err != nil {
+				return "", err
+			}
+		} else if err := os.RemoveAll(localPathTmpValidator); err != nil {
+			return "", err
+		}
+	}
+
+	f, err := os.OpenFile(localPathTmp, tmpFlags, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	var w io.Writer = f
+	if progress != nil {
+		w = &progressWriter{w: f, written: offset, total: total, f: progress}
+		progress(Progress{Written: offset, Total: total})
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", errors.Wrapf(err, "failed to download %q", url)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if progress != nil {
+		st, err := os.Stat(localPathTmp)
+		if err != nil {
+			return "", err
+		}
+		progress(Progress{Written: st.Size(), Total: total, Done: true})
+	}
+	return validator, nil
+}
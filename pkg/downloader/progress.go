@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/mattn/go-isatty"
+)
+
+// Progress is the progress of an HTTP download.
+type Progress struct {
+	Written int64 // including the bytes written before resuming the download
+	Total   int64 // -1 if unknown
+	Done    bool
+}
+
+// ProgressFunc is called with the progress of an HTTP download.
+type ProgressFunc func(Progress)
+
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	total   int64
+	f       ProgressFunc
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	pw.f(Progress{Written: pw.written, Total: pw.total})
+	return n, err
+}
+
+// TextProgress returns a ProgressFunc that prints the progress to w.
+// The progress is updated in place when w is a terminal, otherwise only the completion is printed.
+func TextProgress(w io.Writer) ProgressFunc {
+	isTerminal := false
+	if f, ok := w.(*os.File); ok {
+		isTerminal = isatty.IsTerminal(f.Fd())
+	}
+	var (
+		mu          sync.Mutex
+		lastPrinted time.Time
+	)
+	return func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !p.Done && (!isTerminal || time.Since(lastPrinted) < 200*time.Millisecond) {
+			return
+		}
+		lastPrinted = time.Now()
+		s := units.BytesSize(float64(p.Written))
+		if p.Total > 0 {
+			s = fmt.Sprintf("%s / %s (%d%%)", s, units.BytesSize(float64(p.Total)), p.Written*100/p.Total)
+		}
+		if isTerminal {
+			// "\033[K" clears the rest of the line
+			fmt.Fprintf(w, "\r%s\033[K", s)
+			if p.Done {
+				fmt.Fprintln(w)
+			}
+			return
+		}
+		fmt.Fprintln(w, s)
+	}
+}
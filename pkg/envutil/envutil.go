@@ -0,0 +1,95 @@
+// Package envutil provides the utilities for propagating environment variables to the guest.
+package envutil
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDenyList is the list of the host environment variables that are never propagated by Filter,
+// as they are specific to the host, or set up by the login shell of the guest.
+var DefaultDenyList = []string{
+	"_",
+	"Apple_*",
+	"DISPLAY",
+	"DYLD_*",
+	"HOME",
+	"HOSTNAME",
+	"LD_*",
+	"LOGNAME",
+	"MAIL",
+	"OLDPWD",
+	"PATH",
+	"PWD",
+	"SHELL",
+	"SHLVL",
+	"SSH_*",
+	"TERM_PROGRAM*",
+	"TERM_SESSION_ID",
+	"TMPDIR",
+	"USER",
+	"XAUTHORITY",
+	"XDG_*",
+	"XPC_*",
+	"__CF*",
+}
+
+// ValidateName validates the name of an environment variable.
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("empty environment variable name")
+	}
+	for i, c := range name {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9') {
+			return errors.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// ParseKeyValue parses "KEY=VALUE".
+func ParseKeyValue(s string) (string, string, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return "", "", errors.Errorf("expected KEY=VALUE, got %q", s)
+	}
+	k, v := s[:i], s[i+1:]
+	if err := ValidateName(k); err != nil {
+		return "", "", err
+	}
+	return k, v, nil
+}
+
+// Match returns true if name matches any of the patterns.
+// The patterns are matched with path.Match, e.g., "SSH_*".
+func Match(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter filters environ ("KEY=VALUE" entries, as in os.Environ).
+// When allow is non-empty, only the variables that match allow are returned.
+// The variables that match deny or DefaultDenyList are never returned, nor the ones with invalid names.
+func Filter(environ, allow, deny []string) []string {
+	var res []string
+	for _, kv := range environ {
+		k, _, err := ParseKeyValue(kv)
+		if err != nil {
+			continue
+		}
+		if len(allow) > 0 && !Match(k, allow) {
+			continue
+		}
+		if Match(k, deny) || Match(k, DefaultDenyList) {
+			continue
+		}
+		res = append(res, kv)
+	}
+	return res
+}
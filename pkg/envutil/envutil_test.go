@@ -0,0 +1,28 @@
+package envutil
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseKeyValue(t *testing.T) {
+	k, v, err := ParseKeyValue("FOO=bar=baz")
+	assert.NilError(t, err)
+	assert.Equal(t, "FOO", k)
+	assert.Equal(t, "bar=baz", v)
+	_, v, err = ParseKeyValue("FOO=")
+	assert.NilError(t, err)
+	assert.Equal(t, "", v)
+	_, _, err = ParseKeyValue("FOO")
+	assert.ErrorContains(t, err, "KEY=VALUE")
+	_, _, err = ParseKeyValue("1FOO=bar")
+	assert.ErrorContains(t, err, "invalid")
+}
+
+func TestFilter(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "HOME=/Users/foo", "SSH_AUTH_SOCK=/tmp/sock", "GOPATH=/go", "GOFLAGS=-v", "EDITOR=vim", "=C:"}
+	assert.DeepEqual(t, []string{"GOPATH=/go", "GOFLAGS=-v", "EDITOR=vim"}, Filter(environ, nil, nil))
+	assert.DeepEqual(t, []string{"GOPATH=/go", "GOFLAGS=-v"}, Filter(environ, []string{"GO*"}, nil))
+	assert.DeepEqual(t, []string{"GOPATH=/go"}, Filter(environ, []string{"GO*", "PATH"}, []string{"GOFLAGS"}))
+}
@@ -11,21 +11,50 @@ type ErrorJSON struct {
 	Message string `json:"message"`
 }
 
+type Protocol = string
+
+const (
+	TCP Protocol = "tcp"
+	UDP Protocol = "udp"
+)
+
 type IPPort struct {
 	IP   net.IP `json:"ip"`
 	Port int    `json:"port"`
+	// Protocol is "tcp" or "udp".
+	// Empty value is interpreted as "tcp", for compatibility with older guest agents.
+	Protocol Protocol `json:"protocol,omitempty"`
 }
 
 func (x *IPPort) String() string {
 	return net.JoinHostPort(x.IP.String(), strconv.Itoa(x.Port))
 }
 
+// ProtocolOrDefault returns x.Protocol, or "tcp" if x.Protocol is empty.
+func (x *IPPort) ProtocolOrDefault() Protocol {
+	if x.Protocol == "" {
+		return TCP
+	}
+	return x.Protocol
+}
+
 type Info struct {
-	// LocalPorts contain 127.0.0.1 and 0.0.0.0.
-	// LocalPorts do NOT contain addresses such as 127.0.0.53 and 192.168.5.15.
-	//
-	// In future, LocalPorts will contain IPv6 addresses (::1 and ::) as well.
+	// LocalPorts contain all the listening addresses, including addresses such as 127.0.0.53 and 192.168.5.15.
+	// LocalPorts contain unconnected UDP sockets as well.
+	// The host agent filters them with the port forwarding rules.
 	LocalPorts []IPPort `json:"localPorts"`
+	// Interfaces contain the network interfaces that are up, excluding the loopback.
+	// Interfaces is empty for older guest agents.
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	// Provision contains the status of the provisioning scripts of the current boot.
+	// Provision is empty before the provisioning starts, and for older guest agents.
+	Provision []Provision `json:"provision,omitempty"`
+}
+
+type Interface struct {
+	Name       string   `json:"name"`
+	MACAddress string   `json:"macAddress,omitempty"`
+	IPs        []net.IP `json:"ips,omitempty"` // global unicast addresses
 }
 
 type Event struct {
@@ -33,5 +62,43 @@ type Event struct {
 	// The first event contains the full ports as LocalPortsAdded
 	LocalPortsAdded   []IPPort `json:"localPortsAdded,omitempty"`
 	LocalPortsRemoved []IPPort `json:"localPortsRemoved,omitempty"`
-	Errors            []string `json:"errors,omitempty"`
+	// The first event contains the full status of the provisioning scripts as Provision,
+	// the subsequent events contain the scripts whose status has changed.
+	Provision []Provision `json:"provision,omitempty"`
+	Errors    []string    `json:"errors,omitempty"`
+}
+
+type ProvisionStatus = string
+
+const (
+	ProvisionPending   ProvisionStatus = "pending"
+	ProvisionRunning   ProvisionStatus = "running"
+	ProvisionSucceeded ProvisionStatus = "succeeded"
+	ProvisionFailed    ProvisionStatus = "failed"
+	// ProvisionSkipped means that the script was not executed, because the script is `runOnce` and has already succeeded,
+	// or because a script in its `after` field did not succeed.
+	ProvisionSkipped ProvisionStatus = "skipped"
+)
+
+// Provision is the status of a provisioning script (the `provision` field of lima.yaml).
+type Provision struct {
+	Name       string          `json:"name"`
+	Mode       string          `json:"mode"`
+	Status     ProvisionStatus `json:"status"`
+	ExitCode   int             `json:"exitCode,omitempty"`
+	StartedAt  time.Time       `json:"startedAt,omitempty"`
+	FinishedAt time.Time       `json:"finishedAt,omitempty"`
+}
+
+// Finished returns true if the script is no longer running.
+func (x *Provision) Finished() bool {
+	return x.Status == ProvisionSucceeded || x.Status == ProvisionFailed || x.Status == ProvisionSkipped
+}
+
+// Duration returns the duration of the execution, or 0 if unknown.
+func (x *Provision) Duration() time.Duration {
+	if x.StartedAt.IsZero() || x.FinishedAt.IsZero() {
+		return 0
+	}
+	return x.FinishedAt.Sub(x.StartedAt)
 }
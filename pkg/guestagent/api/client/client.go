@@ -7,16 +7,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
 	"github.com/AkihiroSuda/lima/pkg/httpclientutil"
+	"github.com/AkihiroSuda/lima/pkg/udptunnel"
+	"github.com/pkg/errors"
 )
 
 type GuestAgentClient interface {
 	HTTPClient() *http.Client
 	Info(context.Context) (*api.Info, error)
 	Events(context.Context, func(api.Event)) error
+	// TunnelUDP opens a tunnel of the UDP datagrams to addr in the guest, see package udptunnel.
+	TunnelUDP(ctx context.Context, addr string) (io.ReadWriteCloser, error)
 }
 
 // NewGuestAgentClient creates a client.
@@ -80,3 +86,30 @@ func (c *client) Events(ctx context.Context, onEvent func(api.Event)) error {
 		onEvent(ev)
 	}
 }
+
+func (c *client) TunnelUDP(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+	u := fmt.Sprintf("http://%s/%s/tunnels/udp?addr=%s", c.dummyHost, c.version, url.QueryEscape(addr))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", udptunnel.UpgradeProtocol)
+	resp, err := c.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		if err := httpclientutil.Successful(resp); err != nil {
+			return nil, err
+		}
+		return nil, errors.Errorf("expected HTTP status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.Errorf("expected io.ReadWriteCloser, got %T", resp.Body)
+	}
+	return rwc, nil
+}
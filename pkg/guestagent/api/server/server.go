@@ -3,11 +3,15 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/AkihiroSuda/lima/pkg/guestagent"
 	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"github.com/AkihiroSuda/lima/pkg/udptunnel"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -74,8 +78,46 @@ func (b *Backend) GetEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetTunnelUDP is the handler for GET /v{N}/tunnels/udp?addr=ADDR .
+// The connection is upgraded to a tunnel of the UDP datagrams to ADDR, see package udptunnel.
+func (b *Backend) GetTunnelUDP(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	if addr == "" {
+		b.onError(w, r, errors.New("query parameter \"addr\" must be set"), http.StatusBadRequest)
+		return
+	}
+	if upgrade := r.Header.Get("Upgrade"); upgrade != udptunnel.UpgradeProtocol {
+		b.onError(w, r, errors.Errorf("expected \"Upgrade: %s\", got %q", udptunnel.UpgradeProtocol, upgrade), http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic("http.ResponseWriter has to implement http.Hijacker")
+	}
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(bufrw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", udptunnel.UpgradeProtocol)
+	if err := bufrw.Flush(); err != nil {
+		logrus.Warn(err)
+		return
+	}
+	// bufrw.Reader may contain the data that was sent before receiving the response
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{bufrw.Reader, conn}
+	if err := udptunnel.Serve(context.Background(), rw, addr); err != nil {
+		logrus.WithError(err).Debugf("UDP tunnel to %q was closed", addr)
+	}
+}
+
 func AddRoutes(r *mux.Router, b *Backend) {
 	v1 := r.PathPrefix("/v1").Subrouter()
 	v1.Path("/info").Methods("GET").HandlerFunc(b.GetInfo)
 	v1.Path("/events").Methods("GET").HandlerFunc(b.GetEvents)
+	v1.Path("/tunnels/udp").Methods("GET").HandlerFunc(b.GetTunnelUDP)
 }
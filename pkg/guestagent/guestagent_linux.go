@@ -24,11 +24,13 @@ type agent struct {
 	// Ticker is like time.Ticker.
 	// We can't use inotify for /proc/net/tcp, so we need this ticker to
 	// reload /proc/net/tcp.
+	// The ticker may tick on socket events rather than periodically, see package ticker.
 	newTicker func() (<-chan time.Time, func())
 }
 
 type eventState struct {
-	ports []api.IPPort
+	ports     []api.IPPort
+	provision []api.Provision
 }
 
 func comparePorts(old, neww []api.IPPort) (added, removed []api.IPPort) {
@@ -36,12 +38,12 @@ func comparePorts(old, neww []api.IPPort) (added, removed []api.IPPort) {
 	mStillExist := make(map[string]bool, len(old))
 
 	for _, f := range old {
-		k := f.String()
+		k := f.ProtocolOrDefault() + "/" + f.String()
 		mRaw[k] = f
 		mStillExist[k] = false
 	}
 	for _, f := range neww {
-		k := f.String()
+		k := f.ProtocolOrDefault() + "/" + f.String()
 		if _, ok := mRaw[k]; !ok {
 			added = append(added, f)
 		}
@@ -71,6 +73,12 @@ func (a *agent) collectEvent(ctx context.Context, st eventState) (api.Event, eve
 		return ev, newSt
 	}
 	ev.LocalPortsAdded, ev.LocalPortsRemoved = comparePorts(st.ports, newSt.ports)
+	if provision, err := readProvision(ProvisionStatusDir); err != nil {
+		ev.Errors = append(ev.Errors, err.Error())
+	} else {
+		newSt.provision = provision
+		ev.Provision = compareProvision(st.provision, newSt.provision)
+	}
 	ev.Time = time.Now()
 	return ev, newSt
 }
@@ -94,9 +102,15 @@ func (a *agent) Events(ctx context.Context, ch chan api.Event) {
 		if !isEventEmpty(ev) {
 			ch <- ev
 		}
+		// The provisioning status is polled more frequently than the ticker, so that a failure is reported quickly
+		var provisionTickCh <-chan time.Time
+		if provisionInProgress(st.provision) {
+			provisionTickCh = time.After(provisionTick)
+		}
 		select {
 		case <-ctx.Done():
 			return
+		case <-provisionTickCh:
 		case _, ok := <-tickerCh:
 			if !ok {
 				return
@@ -111,28 +125,32 @@ func (a *agent) LocalPorts(ctx context.Context) ([]api.IPPort, error) {
 		return nil, errors.New("big endian architecture is unsupported, because I don't know how /proc/net/tcp looks like on big endian hosts")
 	}
 	var res []api.IPPort
-	tcpParsed, err := procnettcp.ParseFiles()
+	parsed, err := procnettcp.ParseFiles()
 	if err != nil {
 		return res, err
 	}
 
-	ipv4Loopback1 := net.ParseIP("127.0.0.1")
-	for _, f := range tcpParsed {
+	for _, f := range parsed {
+		// The host agent decides whether the port is forwarded, according to the port forwarding rules.
 		switch f.Kind {
 		case procnettcp.TCP, procnettcp.TCP6:
-		default:
-			continue
-		}
-		isListen := f.State == procnettcp.TCPListen
-		// We do NOT use net.IP.IsLoopback() here, because we want to exclude addresses like 127.0.0.53 here.
-		isLocal := f.IP.Equal(net.IPv4zero) || f.IP.Equal(net.IPv6zero) ||
-			f.IP.Equal(ipv4Loopback1) || f.IP.Equal(net.IPv6loopback)
-		if isListen && isLocal {
-			res = append(res,
-				api.IPPort{
-					IP:   f.IP,
-					Port: int(f.Port),
-				})
+			if f.State == procnettcp.TCPListen {
+				res = append(res,
+					api.IPPort{
+						IP:       f.IP,
+						Port:     int(f.Port),
+						Protocol: api.TCP,
+					})
+			}
+		case procnettcp.UDP, procnettcp.UDP6:
+			if f.State == procnettcp.UDPUnconnected {
+				res = append(res,
+					api.IPPort{
+						IP:       f.IP,
+						Port:     int(f.Port),
+						Protocol: api.UDP,
+					})
+			}
 		}
 	}
 	return res, nil
@@ -147,5 +165,44 @@ func (a *agent) Info(ctx context.Context) (*api.Info, error) {
 	if err != nil {
 		return nil, err
 	}
+	info.Interfaces, err = interfaces()
+	if err != nil {
+		return nil, err
+	}
+	info.Provision, err = readProvision(ProvisionStatusDir)
+	if err != nil {
+		return nil, err
+	}
 	return &info, nil
 }
+
+// interfaces returns the network interfaces that are up, excluding the loopback.
+func interfaces() ([]api.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var res []api.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		x := api.Interface{
+			Name:       iface.Name,
+			MACAddress: iface.HardwareAddr.String(),
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			x.IPs = append(x.IPs, ipNet.IP)
+		}
+		res = append(res, x)
+	}
+	return res, nil
+}
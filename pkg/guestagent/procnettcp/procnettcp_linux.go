@@ -6,12 +6,14 @@ import (
 	"github.com/pkg/errors"
 )
 
-// ParseFiles parses /proc/net/{tcp, tcp6}
+// ParseFiles parses /proc/net/{tcp, tcp6, udp, udp6}
 func ParseFiles() ([]Entry, error) {
 	var res []Entry
 	files := map[string]Kind{
 		"/proc/net/tcp":  TCP,
 		"/proc/net/tcp6": TCP6,
+		"/proc/net/udp":  UDP,
+		"/proc/net/udp6": UDP6,
 	}
 	for file, kind := range files {
 		r, err := os.Open(file)
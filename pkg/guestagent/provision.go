@@ -0,0 +1,80 @@
+package guestagent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"github.com/pkg/errors"
+)
+
+// ProvisionStatusDir is the directory where the provisioning runner of cloud-init (user-data)
+// writes the status of each provisioning script as "<NAME>.json".
+// The directory is on tmpfs, so the status is reset on every boot.
+const ProvisionStatusDir = "/run/lima-provision"
+
+// cloudInitResult is written by cloud-init when all the stages have finished, on every boot.
+const cloudInitResult = "/run/cloud-init/result.json"
+
+// provisionTick is the interval of polling the provisioning status while the provisioning may be in progress.
+const provisionTick = time.Second
+
+// readProvision reads the status of the provisioning scripts from dir, sorted by the names.
+// A missing dir is not an error, as the provisioning may not have started yet.
+func readProvision(dir string) ([]api.Provision, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []api.Provision
+	for _, e := range entries {
+		// The runner writes "<NAME>.json.tmp" and renames it to "<NAME>.json"
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return res, err
+		}
+		var x api.Provision
+		if err := json.Unmarshal(b, &x); err != nil {
+			return res, errors.Wrapf(err, "failed to parse the provisioning status %q", e.Name())
+		}
+		res = append(res, x)
+	}
+	return res, nil
+}
+
+// provisionInProgress returns true when the provisioning has not finished yet.
+// The provisioning may not have started yet when cloud-init has not finished.
+func provisionInProgress(provision []api.Provision) bool {
+	for _, x := range provision {
+		if !x.Finished() {
+			return true
+		}
+	}
+	_, err := os.Stat(cloudInitResult)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// compareProvision returns the entries of neww that are not in old, or whose status has changed.
+func compareProvision(old, neww []api.Provision) []api.Provision {
+	m := make(map[string]api.Provision, len(old))
+	for _, f := range old {
+		m[f.Name] = f
+	}
+	var changed []api.Provision
+	for _, f := range neww {
+		x, ok := m[f.Name]
+		if !ok || x.Status != f.Status || x.ExitCode != f.ExitCode || !x.StartedAt.Equal(f.StartedAt) || !x.FinishedAt.Equal(f.FinishedAt) {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
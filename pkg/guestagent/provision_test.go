@@ -0,0 +1,49 @@
+package guestagent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"gotest.tools/v3/assert"
+)
+
+func TestReadProvision(t *testing.T) {
+	dir := t.TempDir()
+	res, err := readProvision(filepath.Join(dir, "nonexistent"))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(res))
+
+	files := map[string]string{
+		"vim.json":         `{"name":"vim","mode":"system","status":"failed","exitCode":100,"startedAt":"2021-06-01T00:00:00Z","finishedAt":"2021-06-01T00:00:42Z"}`,
+		"dotfiles.json":    `{"name":"dotfiles","mode":"user","status":"skipped"}`,
+		"motd.json.tmp":    `{"name":"motd"`,
+		"apt-mirror.json":  `{"name":"apt-mirror","mode":"dependency","status":"running","startedAt":"2021-06-01T00:00:00Z"}`,
+		"unrelated.txt":    "",
+		"dotfiles.json.gz": "",
+	}
+	for name, content := range files {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	res, err = readProvision(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(res))
+	assert.Equal(t, "apt-mirror", res[0].Name)
+	assert.Equal(t, false, res[0].Finished())
+	assert.Equal(t, "dotfiles", res[1].Name)
+	assert.Equal(t, api.ProvisionSkipped, res[1].Status)
+	assert.Equal(t, "vim", res[2].Name)
+	assert.Equal(t, 100, res[2].ExitCode)
+	assert.Equal(t, 42*time.Second, res[2].Duration())
+
+	old := res
+	neww := append([]api.Provision(nil), res...)
+	neww[0].Status = api.ProvisionSucceeded
+	neww = append(neww, api.Provision{Name: "motd", Mode: "system", Status: api.ProvisionRunning})
+	changed := compareProvision(old, neww)
+	assert.Equal(t, 2, len(changed))
+	assert.Equal(t, "apt-mirror", changed[0].Name)
+	assert.Equal(t, "motd", changed[1].Name)
+}
@@ -0,0 +1,270 @@
+package ticker
+
+import (
+	"encoding/binary"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yalue/native_endian"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tcpListen is TCP_LISTEN in include/net/tcp_states.h
+	tcpListen = 10
+	// bpfFuncRingbufOutput is BPF_FUNC_ringbuf_output in include/uapi/linux/bpf.h
+	bpfFuncRingbufOutput = 130
+	// ebpfDebounce is the delay before ticking after receiving an event.
+	// The delay is needed because "sys_enter" is fired before the socket is actually bound,
+	// and also for coalescing bursts of the events.
+	ebpfDebounce = 100 * time.Millisecond
+)
+
+// NewEBPFTicker returns a ticker that ticks when a TCP socket starts or stops listening,
+// or when bind(2) is called (for UDP sockets).
+//
+// The ticker is implemented with eBPF programs attached to the raw tracepoints
+// "inet_sock_set_state" and "sys_enter", which notify the agent via a BPF ring buffer.
+//
+// Closing UDP sockets is not detected, so the ticker should be combined with NewSimpleTicker.
+//
+// NewEBPFTicker requires Linux 5.8 or later, and CAP_BPF + CAP_PERFMON (or CAP_SYS_ADMIN).
+func NewEBPFTicker() (<-chan time.Time, func(), error) {
+	raiseMemlockLimit()
+	pageSize := os.Getpagesize()
+	var closers []func() error
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				logrus.WithError(err).Debug("failed to close an eBPF resource")
+			}
+		}
+	}
+	mapFD, err := bpfRingbufCreate(uint32(pageSize))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create a BPF ring buffer")
+	}
+	closers = append(closers, func() error { return unix.Close(mapFD) })
+
+	programs := map[string][]bpfInsn{
+		// args: (const struct sock *sk, const int oldstate, const int newstate)
+		"inet_sock_set_state": {
+			insn(unix.BPF_LDX|unix.BPF_DW|unix.BPF_MEM, 2, 1, 8, 0),  // r2 = oldstate
+			insn(unix.BPF_LDX|unix.BPF_DW|unix.BPF_MEM, 3, 1, 16, 0), // r3 = newstate
+			insn(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 2, 0, 2, tcpListen),
+			insn(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 3, 0, 1, tcpListen),
+		},
+		// args: (struct pt_regs *regs, long id)
+		"sys_enter": {
+			insn(unix.BPF_LDX|unix.BPF_DW|unix.BPF_MEM, 2, 1, 8, 0), // r2 = id
+			insn(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 2, 0, 1, unix.SYS_BIND),
+		},
+	}
+	for tp, filter := range programs {
+		progFD, err := bpfProgLoad(assembleNotifier(filter, mapFD))
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "failed to load an eBPF program for %q", tp)
+		}
+		closers = append(closers, func() error { return unix.Close(progFD) })
+		linkFD, err := bpfRawTracepointOpen(tp, progFD)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "failed to attach an eBPF program to %q", tp)
+		}
+		closers = append(closers, func() error { return unix.Close(linkFD) })
+	}
+
+	// The consumer position is on the first page, and the producer position is on the second page.
+	consumerPage, err := unix.Mmap(mapFD, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		closeAll()
+		return nil, nil, errors.Wrap(err, "failed to mmap the consumer page of the BPF ring buffer")
+	}
+	closers = append(closers, func() error { return unix.Munmap(consumerPage) })
+	producerPage, err := unix.Mmap(mapFD, int64(pageSize), pageSize, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		closeAll()
+		return nil, nil, errors.Wrap(err, "failed to mmap the producer page of the BPF ring buffer")
+	}
+	closers = append(closers, func() error { return unix.Munmap(producerPage) })
+	consumerPos := (*uint64)(unsafe.Pointer(&consumerPage[0]))
+	producerPos := (*uint64)(unsafe.Pointer(&producerPage[0]))
+	// drain discards the records; we are only interested in the existence of the records.
+	drain := func() {
+		atomic.StoreUint64(consumerPos, atomic.LoadUint64(producerPos))
+	}
+
+	stopR, stopW, err := os.Pipe()
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	closers = append(closers, stopR.Close)
+
+	ch := make(chan time.Time, 1)
+	go func() {
+		defer closeAll()
+		fds := []unix.PollFd{
+			{Fd: int32(mapFD), Events: unix.POLLIN},
+			{Fd: int32(stopR.Fd()), Events: unix.POLLIN},
+		}
+		for {
+			if _, err := unix.Poll(fds, -1); err != nil {
+				if errors.Is(err, unix.EINTR) {
+					continue
+				}
+				logrus.WithError(err).Warn("failed to poll the BPF ring buffer")
+				return
+			}
+			if fds[1].Revents != 0 {
+				return
+			}
+			if fds[0].Revents&unix.POLLIN != 0 {
+				drain()
+				time.Sleep(ebpfDebounce)
+				drain()
+				select {
+				case ch <- time.Now():
+				default:
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			_ = stopW.Close()
+		})
+	}
+	return ch, stop, nil
+}
+
+// raiseMemlockLimit raises RLIMIT_MEMLOCK to the hard limit.
+// Needed for Linux prior to 5.11, which charges BPF maps to RLIMIT_MEMLOCK rather than to memcg.
+func raiseMemlockLimit() {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rlim); err != nil {
+		return
+	}
+	rlim.Cur = rlim.Max
+	_ = unix.Setrlimit(unix.RLIMIT_MEMLOCK, &rlim)
+}
+
+// bpfInsn is struct bpf_insn in include/uapi/linux/bpf.h
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // dst_reg:4, src_reg:4
+	Off  int16
+	Imm  int32
+}
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	regs := dst | src<<4
+	if native_endian.NativeEndian() == binary.BigEndian {
+		regs = dst<<4 | src
+	}
+	return bpfInsn{Code: code, Regs: regs, Off: off, Imm: imm}
+}
+
+// assembleNotifier returns a program that outputs a record to the ring buffer mapFD.
+// The filter has to jump to the instruction right after the end of the filter + 1 to output a record.
+// Otherwise the filter falls through to the instruction that skips outputting a record.
+func assembleNotifier(filter []bpfInsn, mapFD int) []bpfInsn {
+	notify := []bpfInsn{
+		insn(unix.BPF_ST|unix.BPF_DW|unix.BPF_MEM, 10, 0, -8, 0),                               // *(u64 *)(r10 - 8) = 0
+		insn(unix.BPF_LD|unix.BPF_DW|unix.BPF_IMM, 1, unix.BPF_PSEUDO_MAP_FD, 0, int32(mapFD)), // r1 = map (64-bit immediate)
+		insn(0, 0, 0, 0, 0),
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, 2, 10, 0, 0), // r2 = r10
+		insn(unix.BPF_ALU64|unix.BPF_ADD|unix.BPF_K, 2, 0, 0, -8), // r2 += -8
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, 3, 0, 0, 8),  // r3 = 8 (size)
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, 4, 0, 0, 0),  // r4 = 0 (flags)
+		insn(unix.BPF_JMP|unix.BPF_CALL, 0, 0, 0, bpfFuncRingbufOutput),
+	}
+	var prog []bpfInsn
+	prog = append(prog, filter...)
+	prog = append(prog, insn(unix.BPF_JMP|unix.BPF_JA, 0, 0, int16(len(notify)), 0)) // skip notify
+	prog = append(prog, notify...)
+	prog = append(prog,
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, 0, 0, 0, 0), // r0 = 0
+		insn(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0),
+	)
+	return prog
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+func bpfRingbufCreate(size uint32) (int, error) {
+	attr := struct {
+		MapType    uint32
+		KeySize    uint32
+		ValueSize  uint32
+		MaxEntries uint32
+	}{
+		MapType:    unix.BPF_MAP_TYPE_RINGBUF,
+		MaxEntries: size,
+	}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func bpfProgLoad(prog []bpfInsn) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	logBuf := make([]byte, 64*1024)
+	attr := struct {
+		ProgType uint32
+		InsnCnt  uint32
+		Insns    uint64
+		License  uint64
+		LogLevel uint32
+		LogSize  uint32
+		LogBuf   uint64
+	}{
+		ProgType: unix.BPF_PROG_TYPE_RAW_TRACEPOINT,
+		InsnCnt:  uint32(len(prog)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel: 1,
+		LogSize:  uint32(len(logBuf)),
+		LogBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	if err != nil {
+		if verifierLog := unix.ByteSliceToString(logBuf); verifierLog != "" {
+			return -1, errors.Wrapf(err, "verifier log: %s", verifierLog)
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+func bpfRawTracepointOpen(name string, progFD int) (int, error) {
+	nameBytes, err := unix.BytePtrFromString(name)
+	if err != nil {
+		return -1, err
+	}
+	attr := struct {
+		Name   uint64
+		ProgFD uint32
+		_      uint32
+	}{
+		Name:   uint64(uintptr(unsafe.Pointer(nameBytes))),
+		ProgFD: uint32(progFD),
+	}
+	fd, err := bpf(unix.BPF_RAW_TRACEPOINT_OPEN, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(nameBytes)
+	return fd, err
+}
@@ -0,0 +1,46 @@
+// Package ticker provides the tickers for guestagent.New.
+//
+// A ticker is a pair of a channel like time.Ticker.C and a function to stop the ticker.
+package ticker
+
+import (
+	"sync"
+	"time"
+)
+
+// NewSimpleTicker returns a ticker that ticks every d, for polling the events.
+func NewSimpleTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// NewCompoundTicker returns a ticker that ticks when either of the tickers ticks.
+func NewCompoundTicker(t1 <-chan time.Time, stop1 func(), t2 <-chan time.Time, stop2 func()) (<-chan time.Time, func()) {
+	ch := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		for {
+			var t time.Time
+			select {
+			case t = <-t1:
+			case t = <-t2:
+			case <-done:
+				return
+			}
+			select {
+			case ch <- t:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			stop1()
+			stop2()
+			close(done)
+		})
+	}
+	return ch, stop
+}
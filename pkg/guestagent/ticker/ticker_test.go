@@ -0,0 +1,24 @@
+package ticker
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCompoundTicker(t *testing.T) {
+	t1 := make(chan time.Time)
+	t2 := make(chan time.Time)
+	var stopped1, stopped2 bool
+	ch, stop := NewCompoundTicker(t1, func() { stopped1 = true }, t2, func() { stopped2 = true })
+	now := time.Now()
+	t1 <- now
+	assert.Equal(t, now, <-ch)
+	t2 <- now.Add(time.Second)
+	assert.Equal(t, now.Add(time.Second), <-ch)
+	stop()
+	stop()
+	assert.Assert(t, stopped1)
+	assert.Assert(t, stopped2)
+}
@@ -4,6 +4,11 @@ import (
 	"time"
 )
 
+// ErrorJSON is returned with "application/json" content type and non-2XX status code
+type ErrorJSON struct {
+	Message string `json:"message"`
+}
+
 type Status struct {
 	Running bool `json:"running,omitempty"`
 	// When Degraded is true, Running must be true as well
@@ -14,9 +19,47 @@ type Status struct {
 	Errors []string `json:"errors,omitempty"`
 
 	SSHLocalPort int `json:"sshLocalPort,omitempty"`
+
+	// DegradedMounts is the list of the mount points that are not healthy.
+	// When DegradedMounts is not empty, Degraded must be true as well.
+	DegradedMounts []string `json:"degradedMounts,omitempty"`
+
+	// IPAddresses are the guest IP addresses on the networks other than the user network.
+	IPAddresses []string `json:"ipAddresses,omitempty"`
 }
 
 type Event struct {
 	Time   time.Time `json:"time,omitempty"`
 	Status Status    `json:"status,omitempty"`
 }
+
+type Info struct {
+	SSHLocalPort int `json:"sshLocalPort,omitempty"`
+	// Status is the status of the latest event
+	Status Status `json:"status"`
+}
+
+type PortForward struct {
+	Protocol string `json:"protocol"` // "tcp"
+	Guest    string `json:"guest"`    // "127.0.0.1:8080"
+	Host     string `json:"host"`     // "127.0.0.1:8080"
+}
+
+type Mount struct {
+	Location   string `json:"location"`   // host path
+	MountPoint string `json:"mountPoint"` // guest path
+	Writable   bool   `json:"writable,omitempty"`
+	MountType  string `json:"mountType,omitempty"` // "reverse-sshfs", "9p", or "virtiofs"
+	// SSHFS, UID, GID, and Umask are the options of reverse-sshfs mounts, as in lima.yaml
+	SSHFS    *SSHFS `json:"sshfs,omitempty"`
+	UID      *int   `json:"uid,omitempty"`
+	GID      *int   `json:"gid,omitempty"`
+	Umask    string `json:"umask,omitempty"`
+	Degraded bool   `json:"degraded,omitempty"` // true if the health check failed
+	Error    string `json:"error,omitempty"`    // the error of the last health check or remount
+}
+
+type SSHFS struct {
+	Cache          *bool `json:"cache,omitempty"`
+	FollowSymlinks *bool `json:"followSymlinks,omitempty"`
+}
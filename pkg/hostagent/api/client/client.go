@@ -0,0 +1,167 @@
+package client
+
+// Forked from https://github.com/rootless-containers/rootlesskit/blob/v0.14.2/pkg/api/client/client.go
+// Apache License 2.0
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	"github.com/AkihiroSuda/lima/pkg/httpclientutil"
+)
+
+type HostAgentClient interface {
+	HTTPClient() *http.Client
+	Info(context.Context) (*api.Info, error)
+	// Events calls onEvent for each event until onEvent returns true.
+	// Events returns io.EOF when the host agent closed the stream.
+	Events(context.Context, func(api.Event) bool) error
+	PortForwards(context.Context) ([]api.PortForward, error)
+	Mounts(context.Context) ([]api.Mount, error)
+	// AddMount adds a reverse-sshfs mount to the running instance.
+	AddMount(context.Context, api.Mount) (*api.Mount, error)
+	RemoveMount(ctx context.Context, mountPoint string) error
+	Shutdown(context.Context) error
+}
+
+// NewHostAgentClient creates a client.
+// socketPath is a path to the UNIX socket, without unix:// prefix.
+func NewHostAgentClient(socketPath string) (HostAgentClient, error) {
+	hc, err := httpclientutil.NewHTTPClientWithSocketPath(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewHostAgentClientWithHTTPClient(hc), nil
+}
+
+// Connect creates a client, and checks that the API is responding.
+// Unlike NewHostAgentClient, Connect fails when the socket is missing or stale.
+func Connect(ctx context.Context, socketPath string) (HostAgentClient, error) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return nil, err
+	}
+	c, err := NewHostAgentClient(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Info(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func NewHostAgentClientWithHTTPClient(hc *http.Client) HostAgentClient {
+	return &client{
+		Client:    hc,
+		version:   "v1",
+		dummyHost: "lima-hostagent",
+	}
+}
+
+type client struct {
+	*http.Client
+	// version is always "v1"
+	// TODO(AkihiroSuda): negotiate the version
+	version   string
+	dummyHost string
+}
+
+func (c *client) HTTPClient() *http.Client {
+	return c.Client
+}
+
+func (c *client) getJSON(ctx context.Context, path string, v interface{}) error {
+	u := fmt.Sprintf("http://%s/%s/%s", c.dummyHost, c.version, path)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	return dec.Decode(v)
+}
+
+func (c *client) Info(ctx context.Context) (*api.Info, error) {
+	var info api.Info
+	if err := c.getJSON(ctx, "info", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *client) Events(ctx context.Context, onEvent func(api.Event) bool) error {
+	u := fmt.Sprintf("http://%s/%s/events", c.dummyHost, c.version)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev api.Event
+		if err := dec.Decode(&ev); err != nil {
+			return err
+		}
+		if stop := onEvent(ev); stop {
+			return nil
+		}
+	}
+}
+
+func (c *client) PortForwards(ctx context.Context) ([]api.PortForward, error) {
+	var portForwards []api.PortForward
+	if err := c.getJSON(ctx, "portforwards", &portForwards); err != nil {
+		return nil, err
+	}
+	return portForwards, nil
+}
+
+func (c *client) Mounts(ctx context.Context) ([]api.Mount, error) {
+	var mounts []api.Mount
+	if err := c.getJSON(ctx, "mounts", &mounts); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+func (c *client) AddMount(ctx context.Context, m api.Mount) (*api.Mount, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("http://%s/%s/mounts", c.dummyHost, c.version)
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res api.Mount
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *client) RemoveMount(ctx context.Context, mountPoint string) error {
+	u := fmt.Sprintf("http://%s/%s/mounts?mountPoint=%s", c.dummyHost, c.version, url.QueryEscape(mountPoint))
+	resp, err := httpclientutil.Delete(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *client) Shutdown(ctx context.Context) error {
+	u := fmt.Sprintf("http://%s/%s/shutdown", c.dummyHost, c.version)
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
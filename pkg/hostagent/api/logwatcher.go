@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/logrusutil"
+	"github.com/nxadm/tail"
+	"github.com/sirupsen/logrus"
+)
+
+// PropagateLogs propagates the JSON logs of the host agent (ha.stderr.log) to the standard logger, until ctx is done.
+// The events are not read from the logs; use the Events method of the client.
+func PropagateLogs(ctx context.Context, haStderrPath string) error {
+	begin := time.Now()
+	haStderrTail, err := tail.TailFile(haStderrPath,
+		tail.Config{
+			Follow:    true,
+			MustExist: true,
+		})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = haStderrTail.Stop()
+		haStderrTail.Cleanup()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line := <-haStderrTail.Lines:
+			if line.Err != nil {
+				logrus.Error(line.Err)
+			}
+			logrusutil.PropagateJSON(logrus.StandardLogger(), []byte(line.Text), "[hostagent] ", begin)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/AkihiroSuda/lima/pkg/hostagent"
+	"github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Backend struct {
+	Agent *hostagent.HostAgent
+}
+
+func (b *Backend) onError(w http.ResponseWriter, r *http.Request, err error, ec int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ec)
+	// it is safe to return the err to the client, because the client is reliable
+	e := api.ErrorJSON{
+		Message: err.Error(),
+	}
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+func (b *Backend) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(m)
+}
+
+// GetInfo is the handler for GET /v{N}/info
+func (b *Backend) GetInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	info, err := b.Agent.Info(ctx)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	b.writeJSON(w, r, info)
+}
+
+// GetEvents is the handler for GET /v{N}/events.
+// The first event is the latest event emitted before the request.
+// The stream ends after an event with the "exiting" status.
+func (b *Backend) GetEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic("http.ResponseWriter has to implement http.Flusher")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := make(chan api.Event)
+	go b.Agent.Events(ctx, ch)
+
+	enc := json.NewEncoder(w)
+	for ev := range ch {
+		if err := enc.Encode(ev); err != nil {
+			logrus.Warn(err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// GetPortForwards is the handler for GET /v{N}/portforwards
+func (b *Backend) GetPortForwards(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	portForwards, err := b.Agent.PortForwards(ctx)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	b.writeJSON(w, r, portForwards)
+}
+
+// GetMounts is the handler for GET /v{N}/mounts
+func (b *Backend) GetMounts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mounts, err := b.Agent.Mounts(ctx)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	b.writeJSON(w, r, mounts)
+}
+
+// PostMounts is the handler for POST /v{N}/mounts.
+// The request body is api.Mount. Only reverse-sshfs mounts can be added.
+func (b *Backend) PostMounts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var req api.Mount
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	m := limayaml.Mount{
+		Location:   req.Location,
+		MountPoint: req.MountPoint,
+		Writable:   req.Writable,
+		MountType:  req.MountType,
+		UID:        req.UID,
+		GID:        req.GID,
+		Umask:      req.Umask,
+	}
+	if req.SSHFS != nil {
+		m.SSHFS = limayaml.SSHFS{
+			Cache:          req.SSHFS.Cache,
+			FollowSymlinks: req.SSHFS.FollowSymlinks,
+		}
+	}
+	mount, err := b.Agent.AddMount(ctx, m)
+	if err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	b.writeJSON(w, r, mount)
+}
+
+// DeleteMounts is the handler for DELETE /v{N}/mounts?mountPoint={MOUNTPOINT}
+func (b *Backend) DeleteMounts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mountPoint := r.URL.Query().Get("mountPoint")
+	if mountPoint == "" {
+		b.onError(w, r, errors.New("query parameter \"mountPoint\" is required"), http.StatusBadRequest)
+		return
+	}
+	if err := b.Agent.RemoveMount(ctx, mountPoint); err != nil {
+		ec := http.StatusBadRequest
+		if errors.Is(err, os.ErrNotExist) {
+			ec = http.StatusNotFound
+		}
+		b.onError(w, r, err, ec)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostShutdown is the handler for POST /v{N}/shutdown.
+// PostShutdown returns without waiting for the host agent to exit.
+func (b *Backend) PostShutdown(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := b.Agent.Shutdown(ctx); err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func AddRoutes(r *mux.Router, b *Backend) {
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Path("/info").Methods("GET").HandlerFunc(b.GetInfo)
+	v1.Path("/events").Methods("GET").HandlerFunc(b.GetEvents)
+	v1.Path("/portforwards").Methods("GET").HandlerFunc(b.GetPortForwards)
+	v1.Path("/mounts").Methods("GET").HandlerFunc(b.GetMounts)
+	v1.Path("/mounts").Methods("POST").HandlerFunc(b.PostMounts)
+	v1.Path("/mounts").Methods("DELETE").HandlerFunc(b.DeleteMounts)
+	v1.Path("/shutdown").Methods("POST").HandlerFunc(b.PostShutdown)
+}
@@ -0,0 +1,338 @@
+// Package dns implements the DNS forwarder of the host agent (`hostResolver`).
+//
+// The forwarder resolves the queries from the guest with the resolver of the host,
+// so that the names that are only resolvable on the host (e.g., via VPN) are resolvable in the guest too.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// ttl is the TTL of the answers, as the resolver of the host does not expose the original TTLs
+	ttl = 60
+	// queryTimeout is the timeout of resolving a query with the resolver of the host
+	queryTimeout = 10 * time.Second
+	// maxUDPSize is the max size of a UDP response without EDNS0 (RFC 1035)
+	maxUDPSize = 512
+)
+
+// Server is the DNS forwarder listening on 127.0.0.1:Port, for both UDP and TCP.
+type Server struct {
+	l        *logrus.Logger
+	hosts    map[string]net.IP // keyed by the lower-cased FQDNs, e.g., "foo.example.com."
+	resolver *net.Resolver
+	udp      net.PacketConn
+	tcp      net.Listener
+	wg       sync.WaitGroup
+}
+
+// FindFreePort finds a port on 127.0.0.1 that is free for both UDP and TCP.
+// The port is released before returning, so it may be taken by another process
+// before the server binds it, but this is unlikely in practice.
+func FindFreePort() (int, error) {
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		c, err := net.ListenPacket("udp4", l.Addr().String())
+		_ = l.Close()
+		if err != nil {
+			continue
+		}
+		_ = c.Close()
+		return port, nil
+	}
+	return 0, errors.New("failed to find a port that is free for both UDP and TCP")
+}
+
+// Start starts the server on 127.0.0.1:port.
+// hosts are the static entries that take precedence over the resolver of the host.
+func Start(l *logrus.Logger, port int, hosts map[string]net.IP) (*Server, error) {
+	s := &Server{
+		l:        l,
+		hosts:    make(map[string]net.IP),
+		resolver: net.DefaultResolver,
+	}
+	for name, ip := range hosts {
+		s.hosts[fqdn(name)] = ip
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	var err error
+	s.udp, err = net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.tcp, err = net.Listen("tcp4", addr)
+	if err != nil {
+		_ = s.udp.Close()
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	udpErr := s.udp.Close()
+	tcpErr := s.tcp.Close()
+	s.wg.Wait()
+	if udpErr != nil {
+		return udpErr
+	}
+	return tcpErr
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.l.WithError(err).Error("failed to read a DNS query (UDP)")
+			}
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			res, err := s.handle(req, true)
+			if err != nil {
+				s.l.WithError(err).Debug("failed to handle a DNS query (UDP)")
+				return
+			}
+			if _, err := s.udp.WriteTo(res, addr); err != nil {
+				s.l.WithError(err).Debug("failed to write a DNS response (UDP)")
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.l.WithError(err).Error("failed to accept a DNS connection (TCP)")
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serveTCPConn(conn); err != nil && !errors.Is(err, io.EOF) {
+				s.l.WithError(err).Debug("failed to handle a DNS connection (TCP)")
+			}
+		}()
+	}
+}
+
+// serveTCPConn serves the queries on conn, prefixed with the 2-byte length (RFC 1035 4.2.2).
+func (s *Server) serveTCPConn(conn net.Conn) error {
+	for {
+		if err := conn.SetDeadline(time.Now().Add(queryTimeout)); err != nil {
+			return err
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+			return err
+		}
+		req := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return err
+		}
+		res, err := s.handle(req, false)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint16(lenBuf[:], uint16(len(res)))
+		if _, err := conn.Write(append(lenBuf[:], res...)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle resolves the query req, and returns the response.
+// Only the first question of the query is answered, as in most of the DNS servers.
+func (s *Server) handle(req []byte, udp bool) ([]byte, error) {
+	var q dnsmessage.Message
+	if err := q.Unpack(req); err != nil {
+		return nil, err
+	}
+	res := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 q.ID,
+			Response:           true,
+			OpCode:             q.OpCode,
+			RecursionDesired:   q.RecursionDesired,
+			RecursionAvailable: true,
+		},
+	}
+	if q.Response || q.OpCode != 0 || len(q.Questions) == 0 {
+		res.RCode = dnsmessage.RCodeNotImplemented
+		return res.Pack()
+	}
+	question := q.Questions[0]
+	res.Questions = []dnsmessage.Question{question}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	res.Answers, res.RCode = s.lookup(ctx, question)
+	b, err := res.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if udp && len(b) > udpSize(q) {
+		// Let the client retry with TCP
+		res.Truncated = true
+		res.Answers = nil
+		return res.Pack()
+	}
+	return b, nil
+}
+
+// udpSize returns the max size of a UDP response to the query q.
+func udpSize(q dnsmessage.Message) int {
+	for _, r := range q.Additionals {
+		// The class of the OPT record is the UDP payload size (RFC 6891 6.1.2)
+		if r.Header.Type == dnsmessage.TypeOPT && int(r.Header.Class) > maxUDPSize {
+			return int(r.Header.Class)
+		}
+	}
+	return maxUDPSize
+}
+
+func (s *Server) lookup(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+	name := q.Name.String()
+	host := strings.TrimSuffix(name, ".")
+	hdr := dnsmessage.ResourceHeader{
+		Name:  q.Name,
+		Type:  q.Type,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+	if ip, ok := s.hosts[strings.ToLower(name)]; ok {
+		return ipResources(hdr, q.Type, []net.IP{ip}), dnsmessage.RCodeSuccess
+	}
+	var (
+		res []dnsmessage.Resource
+		err error
+	)
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		var ips []net.IP
+		ips, err = s.resolver.LookupIP(ctx, "ip", host)
+		res = ipResources(hdr, q.Type, ips)
+	case dnsmessage.TypeCNAME:
+		var cname string
+		if cname, err = s.resolver.LookupCNAME(ctx, host); err == nil {
+			res, err = appendResource(res, hdr, cname, func(n dnsmessage.Name) dnsmessage.ResourceBody {
+				return &dnsmessage.CNAMEResource{CNAME: n}
+			})
+		}
+	case dnsmessage.TypeNS:
+		var nss []*net.NS
+		nss, err = s.resolver.LookupNS(ctx, host)
+		for _, ns := range nss {
+			if err != nil {
+				break
+			}
+			res, err = appendResource(res, hdr, ns.Host, func(n dnsmessage.Name) dnsmessage.ResourceBody {
+				return &dnsmessage.NSResource{NS: n}
+			})
+		}
+	case dnsmessage.TypeMX:
+		var mxs []*net.MX
+		mxs, err = s.resolver.LookupMX(ctx, host)
+		for _, mx := range mxs {
+			if err != nil {
+				break
+			}
+			pref := mx.Pref
+			res, err = appendResource(res, hdr, mx.Host, func(n dnsmessage.Name) dnsmessage.ResourceBody {
+				return &dnsmessage.MXResource{Pref: pref, MX: n}
+			})
+		}
+	case dnsmessage.TypeSRV:
+		var srvs []*net.SRV
+		_, srvs, err = s.resolver.LookupSRV(ctx, "", "", host)
+		for _, srv := range srvs {
+			if err != nil {
+				break
+			}
+			srv := srv
+			res, err = appendResource(res, hdr, srv.Target, func(n dnsmessage.Name) dnsmessage.ResourceBody {
+				return &dnsmessage.SRVResource{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: n}
+			})
+		}
+	case dnsmessage.TypeTXT:
+		var txts []string
+		txts, err = s.resolver.LookupTXT(ctx, host)
+		for _, txt := range txts {
+			res = append(res, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.TXTResource{TXT: []string{txt}}})
+		}
+	default:
+		return nil, dnsmessage.RCodeNotImplemented
+	}
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, dnsmessage.RCodeNameError
+		}
+		s.l.WithError(err).Debugf("failed to resolve %q (%v)", host, q.Type)
+		return nil, dnsmessage.RCodeServerFailure
+	}
+	return res, dnsmessage.RCodeSuccess
+}
+
+// ipResources returns the A or AAAA resources of ips, depending on typ.
+// The addresses of the other family are omitted, so that the response is NOERROR with no answers
+// (rather than NXDOMAIN) for the names that only have the addresses of the other family.
+func ipResources(hdr dnsmessage.ResourceHeader, typ dnsmessage.Type, ips []net.IP) []dnsmessage.Resource {
+	var res []dnsmessage.Resource
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		switch {
+		case typ == dnsmessage.TypeA && ip4 != nil:
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			res = append(res, dnsmessage.Resource{Header: hdr, Body: &a})
+		case typ == dnsmessage.TypeAAAA && ip4 == nil && ip.To16() != nil:
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip.To16())
+			res = append(res, dnsmessage.Resource{Header: hdr, Body: &aaaa})
+		}
+	}
+	return res
+}
+
+func appendResource(res []dnsmessage.Resource, hdr dnsmessage.ResourceHeader, target string,
+	body func(dnsmessage.Name) dnsmessage.ResourceBody) ([]dnsmessage.Resource, error) {
+	n, err := dnsmessage.NewName(fqdn(target))
+	if err != nil {
+		return res, err
+	}
+	return append(res, dnsmessage.Resource{Header: hdr, Body: body(n)}), nil
+}
+
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
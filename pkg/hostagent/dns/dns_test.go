@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gotest.tools/v3/assert"
+)
+
+func TestServer(t *testing.T) {
+	port, err := FindFreePort()
+	assert.NilError(t, err)
+	s, err := Start(logrus.StandardLogger(), port, map[string]net.IP{
+		"Foo.Example.com": net.ParseIP("10.0.0.1"),
+		"bar.example.com": net.ParseIP("fd00::1"),
+	})
+	assert.NilError(t, err)
+	defer s.Close()
+
+	for _, network := range []string{"udp", "tcp"} {
+		network := network
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			},
+		}
+		ips, err := r.LookupIP(context.Background(), "ip", "foo.example.com.")
+		assert.NilError(t, err, network)
+		assert.DeepEqual(t, []net.IP{net.ParseIP("10.0.0.1").To4()}, ips)
+
+		ips, err = r.LookupIP(context.Background(), "ip6", "bar.example.com.")
+		assert.NilError(t, err, network)
+		assert.DeepEqual(t, []net.IP{net.ParseIP("fd00::1")}, ips)
+	}
+}
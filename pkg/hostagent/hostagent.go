@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	guestagentapi "github.com/AkihiroSuda/lima/pkg/guestagent/api"
 	guestagentclient "github.com/AkihiroSuda/lima/pkg/guestagent/api/client"
 	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	"github.com/AkihiroSuda/lima/pkg/hostagent/dns"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
 	"github.com/AkihiroSuda/lima/pkg/qemu"
 	"github.com/AkihiroSuda/lima/pkg/sshutil"
@@ -32,24 +34,65 @@ import (
 type HostAgent struct {
 	l             *logrus.Logger
 	y             *limayaml.LimaYAML
+	instName      string
 	instDir       string
+	disks         []*store.Disk
 	sshConfig     *ssh.SSHConfig
 	portForwarder *portForwarder
 	onClose       []func() error // LIFO
 
-	qExe     string
-	qArgs    []string
-	sigintCh chan os.Signal
+	qExe       string
+	qArgs      []string
+	virtiofsds []qemu.Virtiofsd
+	sigintCh   chan os.Signal
 
-	eventEnc   *json.Encoder
-	eventEncMu sync.Mutex
+	eventEnc    *json.Encoder
+	eventEncMu  sync.Mutex // protects eventEnc, lastEvent, eventSubs, and ipAddresses
+	lastEvent   hostagentapi.Event
+	eventSubs   map[chan hostagentapi.Event]struct{}
+	ipAddresses []string // guest IP addresses, set to the events
+
+	networkMACs map[string]bool // MAC addresses of the networks other than the user network
+
+	dnsLocalPort int // 0 when the DNS forwarder is disabled
+
+	provision   map[string]guestagentapi.Provision // the status of the provisioning scripts, reported by the guest agent
+	provisionMu sync.Mutex                         // protects provision
+	provisionCh chan struct{}                      // notified when provision is updated
+
+	mounts   []*mount
+	mountsMu sync.RWMutex // protects mounts and y.Mounts
+}
+
+type options struct {
+	dnsLocalPort int // default: 0 (disables the DNS forwarder)
+}
+
+type Opt func(*options) error
+
+// WithDNSLocalPort enables the DNS forwarder (`hostResolver`) on 127.0.0.1:port.
+// The port is assigned by pkg/start, as it has to be embedded in the cidata.
+func WithDNSLocalPort(port int) Opt {
+	return func(o *options) error {
+		if port <= 0 || port > 65535 {
+			return errors.Errorf("invalid DNS port %d", port)
+		}
+		o.dnsLocalPort = port
+		return nil
+	}
 }
 
 // New creates the HostAgent.
 //
 // stdout is for emitting JSON lines of Events.
 // stderr is for printing human-readable logs.
-func New(instName string, stdout, stderr io.Writer, sigintCh chan os.Signal) (*HostAgent, error) {
+func New(instName string, stdout, stderr io.Writer, sigintCh chan os.Signal, opts ...Opt) (*HostAgent, error) {
+	var o options
+	for _, f := range opts {
+		if err := f(&o); err != nil {
+			return nil, err
+		}
+	}
 	l := &logrus.Logger{
 		Out:       stderr,
 		Formatter: new(logrus.JSONFormatter),
@@ -67,15 +110,47 @@ func New(instName string, stdout, stderr io.Writer, sigintCh chan os.Signal) (*H
 		return nil, err
 	}
 
+	if y.SSH.LocalPort == 0 {
+		sshLocalPort, err := findFreeTCPLocalPort()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to assign a port for `ssh.localPort`")
+		}
+		// y.SSH.LocalPort is never written back to lima.yaml
+		y.SSH.LocalPort = sshLocalPort
+		sshLocalPortPath := filepath.Join(inst.Dir, filenames.SSHLocalPort)
+		if err := os.WriteFile(sshLocalPortPath, []byte(strconv.Itoa(sshLocalPort)+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	disks, err := store.AdditionalDisks(y)
+	if err != nil {
+		return nil, err
+	}
 	qCfg := qemu.Config{
 		Name:        instName,
 		InstanceDir: inst.Dir,
 		LimaYAML:    y,
 	}
+	for _, d := range disks {
+		qCfg.AdditionalDisks = append(qCfg.AdditionalDisks, d.Path())
+	}
+	limaNetworks, err := store.LimaNetworks(y)
+	if err != nil {
+		return nil, err
+	}
+	qCfg.MulticastAddresses = make(map[string]string)
+	for name, nw := range limaNetworks {
+		qCfg.MulticastAddresses[name] = nw.MulticastAddress
+	}
 	qExe, qArgs, err := qemu.Cmdline(qCfg)
 	if err != nil {
 		return nil, err
 	}
+	virtiofsds, err := qemu.VirtiofsdCmdlines(qCfg)
+	if err != nil {
+		return nil, err
+	}
 
 	sshArgs, err := sshutil.SSHArgs(inst.Dir)
 	if err != nil {
@@ -88,26 +163,131 @@ func New(instName string, stdout, stderr io.Writer, sigintCh chan os.Signal) (*H
 	a := &HostAgent{
 		l:             l,
 		y:             y,
+		instName:      instName,
 		instDir:       inst.Dir,
+		disks:         disks,
 		sshConfig:     sshConfig,
-		portForwarder: newPortForwarder(l, sshConfig, y.SSH.LocalPort),
+		portForwarder: newPortForwarder(l, sshConfig, y.SSH.LocalPort, filepath.Join(inst.Dir, filenames.GuestAgentSock), portForwardRules(y)),
 		qExe:          qExe,
 		qArgs:         qArgs,
+		virtiofsds:    virtiofsds,
 		sigintCh:      sigintCh,
 		eventEnc:      json.NewEncoder(stdout),
+		eventSubs:     make(map[chan hostagentapi.Event]struct{}),
+		networkMACs:   networkMACAddresses(instName, y),
+		dnsLocalPort:  o.dnsLocalPort,
+		provision:     make(map[string]guestagentapi.Provision),
+		provisionCh:   make(chan struct{}, 1),
 	}
 	return a, nil
 }
 
+// exitingEventTimeout is the timeout of delivering the "exiting" event to each subscriber.
+const exitingEventTimeout = 5 * time.Second
+
 func (a *HostAgent) emitEvent(ctx context.Context, ev hostagentapi.Event) {
 	a.eventEncMu.Lock()
 	defer a.eventEncMu.Unlock()
 	if ev.Time.IsZero() {
 		ev.Time = time.Now()
 	}
+	if !ev.Status.Exiting {
+		ev.Status.IPAddresses = a.ipAddresses
+	}
 	if err := a.eventEnc.Encode(ev); err != nil {
 		a.l.WithField("event", ev).WithError(err).Error("failed to emit an event")
 	}
+	a.lastEvent = ev
+	for sub := range a.eventSubs {
+		if ev.Status.Exiting {
+			// The "exiting" event is never dropped, as the subscribers wait for it to terminate the stream
+			select {
+			case sub <- ev:
+			case <-time.After(exitingEventTimeout):
+				a.l.WithField("event", ev).Warn("an event subscriber is too slow, failed to deliver the \"exiting\" event")
+			}
+			continue
+		}
+		select {
+		case sub <- ev:
+		default:
+			a.l.WithField("event", ev).Warn("an event subscriber is too slow, dropping the event")
+		}
+	}
+}
+
+// Events sends the latest event and the subsequent events to ch.
+// Events returns after sending an event with the "exiting" status, or when ctx is done.
+// ch is closed on return.
+func (a *HostAgent) Events(ctx context.Context, ch chan hostagentapi.Event) {
+	defer close(ch)
+	sub := make(chan hostagentapi.Event, 32)
+	a.eventEncMu.Lock()
+	ev := a.lastEvent
+	a.eventSubs[sub] = struct{}{}
+	a.eventEncMu.Unlock()
+	defer func() {
+		a.eventEncMu.Lock()
+		delete(a.eventSubs, sub)
+		a.eventEncMu.Unlock()
+	}()
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- ev:
+		}
+		if ev.Status.Exiting {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case ev = <-sub:
+		}
+	}
+}
+
+// Info returns the information of the host agent.
+func (a *HostAgent) Info(ctx context.Context) (*hostagentapi.Info, error) {
+	a.eventEncMu.Lock()
+	st := a.lastEvent.Status
+	a.eventEncMu.Unlock()
+	info := &hostagentapi.Info{
+		SSHLocalPort: a.y.SSH.LocalPort,
+		Status:       st,
+	}
+	return info, nil
+}
+
+// PortForwards returns the ports that are currently forwarded.
+func (a *HostAgent) PortForwards(ctx context.Context) ([]hostagentapi.PortForward, error) {
+	return a.portForwarder.PortForwards(), nil
+}
+
+// Mounts returns the mounts that are currently set up.
+func (a *HostAgent) Mounts(ctx context.Context) ([]hostagentapi.Mount, error) {
+	a.mountsMu.RLock()
+	defer a.mountsMu.RUnlock()
+	res := make([]hostagentapi.Mount, 0, len(a.mounts))
+	for _, m := range a.mounts {
+		res = append(res, m.info)
+	}
+	return res, nil
+}
+
+// Shutdown requests the host agent to shut down, as if SIGINT was received.
+// Shutdown does not wait for the host agent to exit; watch Events for the "exiting" status.
+func (a *HostAgent) Shutdown(ctx context.Context) error {
+	select {
+	case a.sigintCh <- os.Interrupt:
+	default:
+		a.l.Debug("shutdown is already pending")
+	}
+	return nil
 }
 
 func logPipeRoutine(l *logrus.Logger, r io.Reader, header string) {
@@ -128,6 +308,27 @@ func (a *HostAgent) Run(ctx context.Context) error {
 		a.emitEvent(ctx, exitingEv)
 	}()
 
+	unlockDisks, err := a.lockDisks()
+	if err != nil {
+		return err
+	}
+	defer unlockDisks()
+
+	if a.dnsLocalPort != 0 {
+		dnsServer, err := dns.Start(a.l, a.dnsLocalPort, a.y.DNS.Hosts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to start the DNS forwarder on 127.0.0.1:%d", a.dnsLocalPort)
+		}
+		defer dnsServer.Close()
+		a.l.Infof("Started the DNS forwarder on 127.0.0.1:%d (`hostResolver`)", a.dnsLocalPort)
+	}
+
+	stopVirtiofsd, err := a.startVirtiofsd(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopVirtiofsd()
+
 	qCmd := exec.CommandContext(ctx, a.qExe, a.qArgs...)
 	qStdout, err := qCmd.StdoutPipe()
 	if err != nil {
@@ -150,8 +351,8 @@ func (a *HostAgent) Run(ctx context.Context) error {
 		qWaitCh <- qCmd.Wait()
 	}()
 
-	sshLocalPort := a.y.SSH.LocalPort // TODO: support dynamic port
-	if sshLocalPort < 0 {
+	sshLocalPort := a.y.SSH.LocalPort
+	if sshLocalPort <= 0 {
 		return errors.Errorf("invalid ssh local port %d", sshLocalPort)
 	}
 	stBase := hostagentapi.Status{
@@ -173,6 +374,10 @@ func (a *HostAgent) Run(ctx context.Context) error {
 			stRunning.Degraded = true
 			stRunning.Errors = append(stRunning.Errors, haErr.Error())
 		}
+		stRunning.DegradedMounts = a.degradedMounts()
+		if len(stRunning.DegradedMounts) > 0 {
+			stRunning.Degraded = true
+		}
 		stRunning.Running = true
 		a.emitEvent(ctx, hostagentapi.Event{Status: stRunning})
 	}()
@@ -192,6 +397,24 @@ func (a *HostAgent) Run(ctx context.Context) error {
 	}
 }
 
+// lockDisks locks the additional disks, so that they cannot be used by other instances.
+func (a *HostAgent) lockDisks() (func(), error) {
+	unlock := func() {
+		for _, d := range a.disks {
+			if err := d.Unlock(a.instName); err != nil {
+				a.l.WithError(err).Warnf("failed to unlock disk %q", d.Name)
+			}
+		}
+	}
+	for _, d := range a.disks {
+		if err := d.Lock(a.instName); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	return unlock, nil
+}
+
 func (a *HostAgent) shutdownQEMU(ctx context.Context, timeout time.Duration, qCmd *exec.Cmd, qWaitCh <-chan error) error {
 	a.l.Info("Shutting down QEMU with ACPI")
 	qmpSockPath := filepath.Join(a.instDir, filenames.QMPSock)
@@ -249,16 +472,29 @@ func (a *HostAgent) startHostAgentRoutines(ctx context.Context) error {
 	if err != nil {
 		mErr = multierror.Append(mErr, err)
 	}
+	a.mountsMu.Lock()
+	a.mounts = mounts
+	a.mountsMu.Unlock()
 	a.onClose = append(a.onClose, func() error {
+		a.mountsMu.Lock()
+		defer a.mountsMu.Unlock()
 		var unmountMErr error
-		for _, m := range mounts {
+		for _, m := range a.mounts {
 			if unmountErr := m.close(); unmountErr != nil {
 				unmountMErr = multierror.Append(unmountMErr, unmountErr)
 			}
 		}
+		a.mounts = nil
 		return unmountMErr
 	})
+	go a.superviseMounts(ctx)
+	a.onClose = append(a.onClose, a.portForwarder.Close)
 	go a.watchGuestAgentEvents(ctx)
+	go a.refreshHosts(ctx)
+	if err := a.waitForProvision(ctx); err != nil {
+		// Fail fast, without waiting for the readiness probes that may depend on the provisioning
+		return multierror.Append(mErr, err)
+	}
 	if err := a.waitForRequirements(ctx, "optional", a.optionalRequirements()); err != nil {
 		mErr = multierror.Append(mErr, err)
 	}
@@ -313,6 +549,25 @@ func (a *HostAgent) watchGuestAgentEvents(ctx context.Context) {
 	}
 }
 
+// findFreeTCPLocalPort finds a free port on 127.0.0.1.
+// The port is released before returning, so it may be taken by another process
+// before QEMU binds it, but this is unlikely in practice.
+func findFreeTCPLocalPort() (int, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	lTCPAddr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0, errors.Errorf("expected *net.TCPAddr, got %v", l.Addr())
+	}
+	if lTCPAddr.Port <= 0 {
+		return 0, errors.Errorf("unexpected port %d", lTCPAddr.Port)
+	}
+	return lTCPAddr.Port, nil
+}
+
 func isGuestAgentSocketAccessible(ctx context.Context, localUnix string) bool {
 	client, err := guestagentclient.NewGuestAgentClient(localUnix)
 	if err != nil {
@@ -334,6 +589,11 @@ func (a *HostAgent) processGuestAgentEvents(ctx context.Context, localUnix strin
 	}
 
 	a.l.Debugf("guest agent info: %+v", info)
+	a.updateIPAddresses(ctx, info.Interfaces)
+	a.updateProvision(info.Provision)
+	refreshCtx, cancelRefresh := context.WithCancel(ctx)
+	defer cancelRefresh()
+	go a.refreshIPAddresses(refreshCtx, client)
 
 	onEvent := func(ev guestagentapi.Event) {
 		a.l.Debugf("guest agent event: %+v", ev)
@@ -341,6 +601,7 @@ func (a *HostAgent) processGuestAgentEvents(ctx context.Context, localUnix strin
 			a.l.Warnf("received error from the guest: %q", f)
 		}
 		a.portForwarder.OnEvent(ctx, ev)
+		a.updateProvision(ev.Provision)
 	}
 
 	if err := client.Events(ctx, onEvent); err != nil {
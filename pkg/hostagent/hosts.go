@@ -0,0 +1,84 @@
+package hostagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/cidata"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/store"
+	"github.com/AkihiroSuda/sshocker/pkg/ssh"
+	"github.com/pkg/errors"
+)
+
+// hostsRefreshInterval is the interval of refreshing /etc/hosts of the guest
+const hostsRefreshInterval = 30 * time.Second
+
+// refreshHosts keeps the entries of the instances on the networks of type "lima" in /etc/hosts of the guest up to date.
+// The entries written by cidata (17-hosts.boot.sh) only contain the instances that had joined the networks when the instance started.
+func (a *HostAgent) refreshHosts(ctx context.Context) {
+	hasLimaNetwork := false
+	for _, f := range a.y.Networks {
+		if f.Type == limayaml.NetworkLima {
+			hasLimaNetwork = true
+		}
+	}
+	if !hasLimaNetwork {
+		return
+	}
+	ticker := time.NewTicker(hostsRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.refreshHostsOnce(); err != nil {
+			a.l.WithError(err).Warn("failed to refresh /etc/hosts")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *HostAgent) refreshHostsOnce() error {
+	limaNetworks, err := store.LimaNetworks(a.y)
+	if err != nil {
+		return err
+	}
+	cidataNetworks := make(map[string]cidata.LimaNetwork)
+	for name, nw := range limaNetworks {
+		cidataNetworks[name] = cidata.LimaNetwork{Addresses: nw.Addresses}
+	}
+	script := updateHostsScript(cidata.Hosts(a.y, cidataNetworks))
+	stdout, stderr, err := ssh.ExecuteScript("127.0.0.1", a.y.SSH.LocalPort, a.sshConfig, script, "refresh /etc/hosts")
+	if err != nil {
+		return errors.Wrapf(err, "stdout=%q, stderr=%q", stdout, stderr)
+	}
+	return nil
+}
+
+// updateHostsScript returns the script that replaces the "# Lima BEGIN" - "# Lima END" block of /etc/hosts.
+// /etc/hosts is not rewritten when the entries have not changed.
+func updateHostsScript(hosts []cidata.Host) string {
+	var block strings.Builder
+	if len(hosts) > 0 {
+		block.WriteString("# Lima BEGIN\n")
+		for _, h := range hosts {
+			fmt.Fprintf(&block, "%s %s\n", h.IP, strings.Join(h.Names, " "))
+		}
+		block.WriteString("# Lima END\n")
+	}
+	return fmt.Sprintf(`#!/bin/bash
+set -eu -o pipefail
+hosts="$(mktemp)"
+trap 'rm -f "$hosts"' EXIT
+sed '/^# Lima BEGIN$/,/^# Lima END$/d' /etc/hosts >"$hosts"
+cat >>"$hosts" <<'EOF'
+%sEOF
+if ! cmp -s "$hosts" /etc/hosts; then
+	sudo cp "$hosts" /etc/hosts
+fi
+`, block.String())
+}
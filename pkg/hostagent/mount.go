@@ -2,17 +2,30 @@ package hostagent
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
 
+	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
 	"github.com/AkihiroSuda/lima/pkg/localpathutil"
 	"github.com/AkihiroSuda/sshocker/pkg/reversesshfs"
+	"github.com/AkihiroSuda/sshocker/pkg/ssh"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 )
 
 type mount struct {
+	info  hostagentapi.Mount
+	cfg   limayaml.Mount
 	close func() error
+
+	// used by superviseMounts, protected by HostAgent.mountsMu as well as info and close
+	failures  int
+	nextCheck time.Time
 }
 
 func (a *HostAgent) setupMounts(ctx context.Context) ([]*mount, error) {
@@ -23,6 +36,12 @@ func (a *HostAgent) setupMounts(ctx context.Context) ([]*mount, error) {
 	for _, f := range a.y.Mounts {
 		m, err := a.setupMount(ctx, f)
 		if err != nil {
+			if f.MountType == limayaml.ReverseSSHFS {
+				// Retried by superviseMounts, and reported as Status.DegradedMounts until the remount succeeds
+				a.l.WithError(err).Warnf("Failed to mount %q, retrying in background", f.MountPoint)
+				res = append(res, degradedMount(f, err))
+				continue
+			}
 			mErr = multierror.Append(mErr, err)
 			continue
 		}
@@ -31,6 +50,25 @@ func (a *HostAgent) setupMounts(ctx context.Context) ([]*mount, error) {
 	return res, mErr
 }
 
+// degradedMount returns a placeholder for the mount that could not be set up.
+func degradedMount(f limayaml.Mount, err error) *mount {
+	location, _ := localpathutil.Expand(f.Location)
+	return &mount{
+		info: hostagentapi.Mount{
+			Location:   location,
+			MountPoint: f.MountPoint,
+			Writable:   f.Writable,
+			MountType:  f.MountType,
+			Degraded:   true,
+			Error:      err.Error(),
+		},
+		cfg: f,
+		close: func() error {
+			return nil
+		},
+	}
+}
+
 func (a *HostAgent) setupMount(ctx context.Context, m limayaml.Mount) (*mount, error) {
 	expanded, err := localpathutil.Expand(m.Location)
 	if err != nil {
@@ -39,16 +77,34 @@ func (a *HostAgent) setupMount(ctx context.Context, m limayaml.Mount) (*mount, e
 	if err := os.MkdirAll(expanded, 0755); err != nil {
 		return nil, err
 	}
-	a.l.Infof("Mounting %q", expanded)
+	info := hostagentapi.Mount{
+		Location:   expanded,
+		MountPoint: m.MountPoint,
+		Writable:   m.Writable,
+		MountType:  m.MountType,
+	}
+	if m.MountType != limayaml.ReverseSSHFS {
+		// 9p and virtiofs are mounted by the guest (see cidata), and checked in the essential requirements
+		res := &mount{
+			info: info,
+			cfg:  m,
+			close: func() error {
+				return nil
+			},
+		}
+		return res, nil
+	}
+	a.l.Infof("Mounting %q on %q", expanded, m.MountPoint)
+	sshfsArgs := sshfsOptions(m)
 	rsf := &reversesshfs.ReverseSSHFS{
 		SSHConfig:  a.sshConfig,
 		LocalPath:  expanded,
 		Host:       "127.0.0.1",
 		Port:       a.y.SSH.LocalPort,
-		RemotePath: expanded,
+		RemotePath: m.MountPoint,
 		Readonly:   !m.Writable,
 		// NOTE: allow_root requires "user_allow_other" in /etc/fuse.conf
-		SSHFSAdditionalArgs: []string{"-o", "allow_root"},
+		SSHFSAdditionalArgs: append([]string{"-o", "allow_root"}, sshfsArgs...),
 	}
 	if err := rsf.Prepare(); err != nil {
 		return nil, errors.Wrapf(err, "failed to prepare reverse sshfs for %q", expanded)
@@ -56,20 +112,328 @@ func (a *HostAgent) setupMount(ctx context.Context, m limayaml.Mount) (*mount, e
 	if err := rsf.Start(); err != nil {
 		a.l.WithError(err).Warnf("failed to mount reverse sshfs for %q, retrying with `-o nonempty`", expanded)
 		// NOTE: nonempty is not supported for libfuse3: https://github.com/canonical/multipass/issues/1381
-		rsf.SSHFSAdditionalArgs = []string{"-o", "nonempty"}
+		rsf.SSHFSAdditionalArgs = append([]string{"-o", "nonempty"}, sshfsArgs...)
 		if err := rsf.Start(); err != nil {
 			return nil, errors.Wrapf(err, "failed to mount reverse sshfs for %q", expanded)
 		}
 	}
 
 	res := &mount{
+		info: info,
+		cfg:  m,
 		close: func() error {
 			a.l.Infof("Unmounting %q", expanded)
 			if closeErr := rsf.Close(); closeErr != nil {
-				return errors.Wrapf(err, "failed to unmount reverse sshfs for %q", expanded)
+				return errors.Wrapf(closeErr, "failed to unmount reverse sshfs for %q", expanded)
 			}
 			return nil
 		},
 	}
 	return res, nil
 }
+
+// AddMount sets up the mount on the running instance.
+// Only reverse-sshfs mounts can be added, as 9p and virtiofs mounts require QEMU devices.
+// AddMount does not update lima.yaml.
+func (a *HostAgent) AddMount(ctx context.Context, m limayaml.Mount) (*hostagentapi.Mount, error) {
+	a.eventEncMu.Lock()
+	running := a.lastEvent.Status.Running
+	a.eventEncMu.Unlock()
+	if !running {
+		return nil, errors.New("the instance is not running yet")
+	}
+	a.mountsMu.Lock()
+	defer a.mountsMu.Unlock()
+	limayaml.FillMountDefaults(&m, a.y.MountType)
+	if m.MountType != limayaml.ReverseSSHFS {
+		return nil, errors.Errorf("mount type %q cannot be added to a running instance (only %q can be)", m.MountType, limayaml.ReverseSSHFS)
+	}
+	y := *a.y
+	y.Mounts = append(append([]limayaml.Mount{}, a.y.Mounts...), m)
+	if err := limayaml.Validate(y); err != nil {
+		return nil, err
+	}
+	res, err := a.setupMount(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	a.mounts = append(a.mounts, res)
+	a.y.Mounts = y.Mounts
+	return &res.info, nil
+}
+
+// RemoveMount unmounts the mount point on the running instance.
+// Only reverse-sshfs mounts can be removed.
+// RemoveMount does not update lima.yaml.
+func (a *HostAgent) RemoveMount(ctx context.Context, mountPoint string) error {
+	a.mountsMu.Lock()
+	defer a.mountsMu.Unlock()
+	for i, m := range a.mounts {
+		if m.info.MountPoint != mountPoint {
+			continue
+		}
+		if m.info.MountType != limayaml.ReverseSSHFS {
+			return errors.Errorf("mount type %q cannot be removed from a running instance (only %q can be)", m.info.MountType, limayaml.ReverseSSHFS)
+		}
+		if err := m.close(); err != nil {
+			return err
+		}
+		a.mounts = append(a.mounts[:i:i], a.mounts[i+1:]...)
+		var yMounts []limayaml.Mount
+		for _, f := range a.y.Mounts {
+			if f.MountPoint != mountPoint {
+				yMounts = append(yMounts, f)
+			}
+		}
+		a.y.Mounts = yMounts
+		return nil
+	}
+	return errors.Wrapf(os.ErrNotExist, "mount point %q is not mounted", mountPoint)
+}
+
+// sshfsOptions returns the sshfs arguments for the options of the mount.
+func sshfsOptions(m limayaml.Mount) []string {
+	var opts []string
+	if m.SSHFS.Cache != nil && !*m.SSHFS.Cache {
+		// "cache=no" was removed in sshfs 3.0
+		opts = append(opts, "dir_cache=no")
+	}
+	if m.SSHFS.FollowSymlinks != nil && *m.SSHFS.FollowSymlinks {
+		opts = append(opts, "follow_symlinks")
+	}
+	if m.UID != nil {
+		opts = append(opts, fmt.Sprintf("uid=%d", *m.UID))
+	}
+	if m.GID != nil {
+		opts = append(opts, fmt.Sprintf("gid=%d", *m.GID))
+	}
+	if m.Umask != "" {
+		opts = append(opts, "umask="+m.Umask)
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return []string{"-o", strings.Join(opts, ",")}
+}
+
+// startVirtiofsd starts the virtiofsd processes for the virtiofs mounts, and waits for their sockets.
+// The returned function kills the processes.
+func (a *HostAgent) startVirtiofsd(ctx context.Context) (func(), error) {
+	var cmds []*exec.Cmd
+	stop := func() {
+		for _, cmd := range cmds {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+	}
+	for _, v := range a.virtiofsds {
+		cmd := exec.CommandContext(ctx, v.Cmdline[0], v.Cmdline[1:]...)
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		a.l.Debugf("Starting virtiofsd: %v", cmd.Args)
+		if err := cmd.Start(); err != nil {
+			stop()
+			return nil, errors.Wrapf(err, "failed to start %v", cmd.Args)
+		}
+		go logPipeRoutine(a.l, stderr, "virtiofsd[stderr]")
+		cmds = append(cmds, cmd)
+	}
+	for _, v := range a.virtiofsds {
+		if err := waitForFile(ctx, v.Socket, 10*time.Second); err != nil {
+			stop()
+			return nil, errors.Wrapf(err, "virtiofsd did not create %q", v.Socket)
+		}
+	}
+	return stop, nil
+}
+
+func waitForFile(ctx context.Context, path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := os.Stat(path)
+		if err == nil || !errors.Is(err, os.ErrNotExist) || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+const (
+	// mountCheckInterval is the interval of the health checks of the healthy mounts
+	mountCheckInterval = 30 * time.Second
+	// mountSupervisorTick is the granularity of the scheduling of the health checks and the remounts
+	mountSupervisorTick = 5 * time.Second
+	mountBackoffMin     = 5 * time.Second
+	mountBackoffMax     = 5 * time.Minute
+)
+
+// mountBackoff returns the delay before the next remount, after the consecutive failures.
+func mountBackoff(failures int) time.Duration {
+	d := mountBackoffMin
+	for i := 1; i < failures && d < mountBackoffMax; i++ {
+		d *= 2
+	}
+	if d > mountBackoffMax {
+		d = mountBackoffMax
+	}
+	return d
+}
+
+// superviseMounts checks the health of the reverse-sshfs mounts, and remounts the unhealthy ones with backoff.
+// The reverse-sshfs mounts die when the guest reboots, or when the SSH master dies.
+// The mount points of the unhealthy mounts are reported as Status.DegradedMounts.
+func (a *HostAgent) superviseMounts(ctx context.Context) {
+	ticker := time.NewTicker(mountSupervisorTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var due []*mount
+		now := time.Now()
+		a.mountsMu.RLock()
+		for _, m := range a.mounts {
+			if m.cfg.MountType == limayaml.ReverseSSHFS && !now.Before(m.nextCheck) {
+				due = append(due, m)
+			}
+		}
+		a.mountsMu.RUnlock()
+		for _, m := range due {
+			a.superviseMount(ctx, m)
+		}
+		a.updateDegradedMounts(ctx)
+	}
+}
+
+func (a *HostAgent) superviseMount(ctx context.Context, m *mount) {
+	// m.cfg and m.info.MountPoint are immutable
+	mountPoint := m.info.MountPoint
+	a.mountsMu.RLock()
+	degraded := m.info.Degraded
+	a.mountsMu.RUnlock()
+	if !degraded {
+		err := a.checkMount(mountPoint)
+		if err == nil {
+			a.mountsMu.Lock()
+			m.nextCheck = time.Now().Add(mountCheckInterval)
+			a.mountsMu.Unlock()
+			return
+		}
+		a.l.WithError(err).Warnf("Mount %q is not healthy, remounting", mountPoint)
+		a.setMountHealth(m, err)
+	}
+
+	a.mountsMu.RLock()
+	closeOld := m.close
+	a.mountsMu.RUnlock()
+	_ = closeOld()
+	a.cleanupMountPoint(mountPoint)
+	newM, err := a.setupMount(ctx, m.cfg)
+	if err == nil {
+		// setupMount does not fail even when sshfs is not mounted yet, so the mount is checked again
+		err = a.checkMount(newM.info.MountPoint)
+		if err != nil {
+			_ = newM.close()
+		}
+	}
+	if err != nil {
+		a.mountsMu.Lock()
+		m.failures++
+		failures := m.failures
+		backoff := mountBackoff(failures)
+		m.nextCheck = time.Now().Add(backoff)
+		m.close = func() error { return nil }
+		m.info.Degraded = true
+		m.info.Error = err.Error()
+		a.mountsMu.Unlock()
+		a.l.WithError(err).Warnf("Failed to remount %q (attempt %d), retrying in %v", mountPoint, failures, backoff)
+		return
+	}
+	a.l.Infof("Remounted %q", mountPoint)
+	newM.nextCheck = time.Now().Add(mountCheckInterval)
+	a.mountsMu.Lock()
+	defer a.mountsMu.Unlock()
+	for i, f := range a.mounts {
+		if f == m {
+			a.mounts[i] = newM
+			return
+		}
+	}
+	// The mount was removed by RemoveMount during the remount
+	_ = newM.close()
+}
+
+func (a *HostAgent) setMountHealth(m *mount, err error) {
+	a.mountsMu.Lock()
+	defer a.mountsMu.Unlock()
+	m.info.Degraded = err != nil
+	m.info.Error = ""
+	if err != nil {
+		m.info.Error = err.Error()
+	}
+}
+
+// checkMount checks the health of the mount by stat-ing the mount point through SSH.
+// A dead sshfs fails with "Transport endpoint is not connected".
+func (a *HostAgent) checkMount(mountPoint string) error {
+	script := fmt.Sprintf(`#!/bin/bash
+set -eu -o pipefail
+mountpoint -q %[1]s
+timeout 10s stat -t %[1]s/. >/dev/null
+`, shellQuote(mountPoint))
+	stdout, stderr, err := ssh.ExecuteScript("127.0.0.1", a.y.SSH.LocalPort, a.sshConfig, script, "check mount "+mountPoint)
+	if err != nil {
+		return errors.Wrapf(err, "stdout=%q, stderr=%q", stdout, stderr)
+	}
+	return nil
+}
+
+// cleanupMountPoint lazily unmounts the stale sshfs in the guest, so that the mount point can be reused.
+func (a *HostAgent) cleanupMountPoint(mountPoint string) {
+	script := fmt.Sprintf(`#!/bin/bash
+if mountpoint -q %[1]s; then
+	fusermount3 -u -z %[1]s || fusermount -u -z %[1]s || sudo umount -l %[1]s
+fi
+`, shellQuote(mountPoint))
+	if stdout, stderr, err := ssh.ExecuteScript("127.0.0.1", a.y.SSH.LocalPort, a.sshConfig, script, "cleanup mount "+mountPoint); err != nil {
+		a.l.WithError(err).Debugf("failed to clean up %q: stdout=%q, stderr=%q", mountPoint, stdout, stderr)
+	}
+}
+
+// degradedMounts returns the mount points of the degraded mounts.
+func (a *HostAgent) degradedMounts() []string {
+	var degraded []string
+	a.mountsMu.RLock()
+	defer a.mountsMu.RUnlock()
+	for _, m := range a.mounts {
+		if m.info.Degraded {
+			degraded = append(degraded, m.info.MountPoint)
+		}
+	}
+	return degraded
+}
+
+// updateDegradedMounts emits an event when the set of the degraded mounts has changed.
+// Status.Errors does not contain the errors of the reverse-sshfs mounts, so Status.Degraded is
+// cleared once all the mounts are remounted, unless the other requirements have failed.
+func (a *HostAgent) updateDegradedMounts(ctx context.Context) {
+	degraded := a.degradedMounts()
+	a.eventEncMu.Lock()
+	st := a.lastEvent.Status
+	a.eventEncMu.Unlock()
+	if !st.Running || st.Exiting || reflect.DeepEqual(st.DegradedMounts, degraded) {
+		return
+	}
+	st.DegradedMounts = degraded
+	st.Degraded = len(st.Errors) > 0 || len(degraded) > 0
+	a.emitEvent(ctx, hostagentapi.Event{Status: st})
+}
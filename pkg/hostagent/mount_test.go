@@ -0,0 +1,32 @@
+package hostagent
+
+import (
+	"testing"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestSSHFSOptions(t *testing.T) {
+	m := limayaml.Mount{
+		Location:  "/tmp/lima",
+		MountType: limayaml.ReverseSSHFS,
+	}
+	assert.Assert(t, sshfsOptions(m) == nil)
+
+	uid, gid := 1000, 100
+	m.SSHFS.Cache = &[]bool{false}[0]
+	m.SSHFS.FollowSymlinks = &[]bool{true}[0]
+	m.UID = &uid
+	m.GID = &gid
+	m.Umask = "022"
+	assert.DeepEqual(t, []string{"-o", "dir_cache=no,follow_symlinks,uid=1000,gid=100,umask=022"}, sshfsOptions(m))
+}
+
+func TestMountBackoff(t *testing.T) {
+	assert.Equal(t, mountBackoffMin, mountBackoff(0))
+	assert.Equal(t, mountBackoffMin, mountBackoff(1))
+	assert.Equal(t, 2*mountBackoffMin, mountBackoff(2))
+	assert.Equal(t, 4*mountBackoffMin, mountBackoff(3))
+	assert.Equal(t, mountBackoffMax, mountBackoff(100))
+}
@@ -0,0 +1,92 @@
+package hostagent
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"time"
+
+	guestagentapi "github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	guestagentclient "github.com/AkihiroSuda/lima/pkg/guestagent/api/client"
+	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/qemu"
+)
+
+// ipAddressesRefreshInterval is the interval of refreshing the guest IP addresses, as DHCP may take a while.
+const ipAddressesRefreshInterval = 30 * time.Second
+
+// networkMACAddresses returns the MAC addresses of the networks other than the user network.
+func networkMACAddresses(instName string, y *limayaml.LimaYAML) map[string]bool {
+	res := make(map[string]bool)
+	for i, nw := range y.Networks {
+		if nw.Type == limayaml.NetworkUser {
+			continue
+		}
+		res[normalizeMACAddress(qemu.MACAddress(instName, i, nw))] = true
+	}
+	return res
+}
+
+func normalizeMACAddress(s string) string {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return s
+	}
+	return mac.String()
+}
+
+// ipAddresses returns the IP addresses of the interfaces that are connected to the networks other than the user network.
+func ipAddresses(ifaces []guestagentapi.Interface, macAddresses map[string]bool) []string {
+	var res []string
+	for _, iface := range ifaces {
+		if !macAddresses[normalizeMACAddress(iface.MACAddress)] {
+			continue
+		}
+		for _, ip := range iface.IPs {
+			res = append(res, ip.String())
+		}
+	}
+	return res
+}
+
+// updateIPAddresses emits an event when the guest IP addresses have changed.
+func (a *HostAgent) updateIPAddresses(ctx context.Context, ifaces []guestagentapi.Interface) {
+	if len(a.networkMACs) == 0 {
+		return
+	}
+	ips := ipAddresses(ifaces, a.networkMACs)
+	a.eventEncMu.Lock()
+	changed := !reflect.DeepEqual(a.ipAddresses, ips)
+	a.ipAddresses = ips
+	st := a.lastEvent.Status
+	a.eventEncMu.Unlock()
+	if changed {
+		a.l.Infof("Guest IP addresses: %v", ips)
+		if st.Running && !st.Exiting {
+			a.emitEvent(ctx, hostagentapi.Event{Status: st})
+		}
+	}
+}
+
+// refreshIPAddresses refreshes the guest IP addresses periodically, until ctx is done.
+func (a *HostAgent) refreshIPAddresses(ctx context.Context, client guestagentclient.GuestAgentClient) {
+	if len(a.networkMACs) == 0 {
+		return
+	}
+	ticker := time.NewTicker(ipAddressesRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := client.Info(ctx)
+			if err != nil {
+				a.l.WithError(err).Debug("failed to refresh the guest IP addresses")
+				continue
+			}
+			a.updateIPAddresses(ctx, info.Interfaces)
+		}
+	}
+}
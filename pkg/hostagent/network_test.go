@@ -0,0 +1,18 @@
+package hostagent
+
+import (
+	"net"
+	"testing"
+
+	guestagentapi "github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"gotest.tools/v3/assert"
+)
+
+func TestIPAddresses(t *testing.T) {
+	ifaces := []guestagentapi.Interface{
+		{Name: "eth0", MACAddress: "52:54:00:12:34:56", IPs: []net.IP{net.ParseIP("192.168.5.15")}},
+		{Name: "eth1", MACAddress: "52:55:55:ab:cd:ef", IPs: []net.IP{net.ParseIP("192.168.105.2"), net.ParseIP("fd00::2")}},
+	}
+	macAddresses := map[string]bool{normalizeMACAddress("52:55:55:AB:CD:EF"): true}
+	assert.DeepEqual(t, []string{"192.168.105.2", "fd00::2"}, ipAddresses(ifaces, macAddresses))
+}
@@ -2,66 +2,253 @@ package hostagent
 
 import (
 	"context"
-	"strconv"
+	"io"
+	"net"
+	"sort"
+	"sync"
 
 	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	guestagentclient "github.com/AkihiroSuda/lima/pkg/guestagent/api/client"
+	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/udptunnel"
 	"github.com/AkihiroSuda/sshocker/pkg/ssh"
 	"github.com/sirupsen/logrus"
 )
 
 type portForwarder struct {
-	l           *logrus.Logger
-	sshConfig   *ssh.SSHConfig
-	sshHostPort int
-	tcp         map[int]struct{} // key: int (NOTE: this might be inconsistent with the actual status of SSH master)
+	l              *logrus.Logger
+	sshConfig      *ssh.SSHConfig
+	sshHostPort    int
+	guestAgentSock string // the guest agent socket on the host, used for tunneling UDP
+	rules          []limayaml.PortForward
+	tcp            map[string]string // key: host address, value: guest address (NOTE: this might be inconsistent with the actual status of SSH master)
+	tcpMu          sync.RWMutex
+	udp            map[string]*udpForward // key: host address
+	udpMu          sync.RWMutex
+}
+
+type udpForward struct {
+	remote    string
+	forwarder *udptunnel.Forwarder
 }
 
 const sshGuestPort = 22
 
-func newPortForwarder(l *logrus.Logger, sshConfig *ssh.SSHConfig, sshHostPort int) *portForwarder {
+func newPortForwarder(l *logrus.Logger, sshConfig *ssh.SSHConfig, sshHostPort int, guestAgentSock string, rules []limayaml.PortForward) *portForwarder {
 	return &portForwarder{
-		l:           l,
-		sshConfig:   sshConfig,
-		sshHostPort: sshHostPort,
-		tcp:         make(map[int]struct{}),
+		l:              l,
+		sshConfig:      sshConfig,
+		sshHostPort:    sshHostPort,
+		guestAgentSock: guestAgentSock,
+		rules:          rules,
+		tcp:            make(map[string]string),
+		udp:            make(map[string]*udpForward),
 	}
 }
 
-func (pf *portForwarder) OnEvent(ctx context.Context, ev api.Event) {
-	ignore := func(x api.IPPort) bool {
-		switch x.Port {
-		case sshGuestPort, pf.sshHostPort:
-			return true
+// portForwardRules returns the rules from the YAML, surrounded by the internal rules.
+// The rules are checked sequentially until the first one matches.
+func portForwardRules(y *limayaml.LimaYAML) []limayaml.PortForward {
+	rules := make([]limayaml.PortForward, 0, 4+len(y.PortForwards))
+	// Block ports 22 and sshLocalPort on all IPs
+	// (only TCP, as SSH does not listen on UDP)
+	for _, port := range []int{sshGuestPort, y.SSH.LocalPort} {
+		rule := limayaml.PortForward{GuestIP: net.IPv4zero, GuestPort: port, Ignore: true}
+		limayaml.FillPortForwardDefaults(&rule)
+		rules = append(rules, rule)
+	}
+	rules = append(rules, y.PortForwards...)
+	// Default forwards for all TCP ports from "127.0.0.1" and "::1" (and "0.0.0.0" and "::")
+	tcpRule := limayaml.PortForward{GuestIP: limayaml.IPv4loopback1, Proto: limayaml.TCP}
+	limayaml.FillPortForwardDefaults(&tcpRule)
+	rules = append(rules, tcpRule)
+	// UDP ports are only forwarded by default when they are unprivileged (>= 1024),
+	// as the privileged ports are used by the system daemons such as the DHCP client (68), which listens on UDP "0.0.0.0".
+	udpRule := limayaml.PortForward{GuestIP: limayaml.IPv4loopback1, GuestPortRange: [2]int{1024, 65535}, Proto: limayaml.UDP}
+	limayaml.FillPortForwardDefaults(&udpRule)
+	rules = append(rules, udpRule)
+	return rules
+}
+
+// hostAddress returns the host address for the guest port, according to the matched rule.
+func hostAddress(rule limayaml.PortForward, guest api.IPPort) string {
+	host := api.IPPort{
+		IP:   rule.HostIP,
+		Port: guest.Port + rule.HostPortRange[0] - rule.GuestPortRange[0],
+	}
+	return host.String()
+}
+
+// forwardingAddresses returns the host address and the guest address for the guest port.
+// The host address is empty when the guest port is not forwarded.
+func (pf *portForwarder) forwardingAddresses(guest api.IPPort) (string, string) {
+	for _, rule := range pf.rules {
+		if rule.Proto != guest.ProtocolOrDefault() {
+			continue
+		}
+		if guest.Port < rule.GuestPortRange[0] || guest.Port > rule.GuestPortRange[1] {
+			continue
+		}
+		switch {
+		case guest.IP.IsUnspecified():
+		case guest.IP.Equal(rule.GuestIP):
+		case guest.IP.Equal(net.IPv6loopback) && rule.GuestIP.Equal(limayaml.IPv4loopback1):
+		case rule.GuestIP.IsUnspecified():
+			// When the rule's GuestIP is unspecified, it matches any IP
 		default:
-			return false
+			continue
 		}
+		if guest.IP.IsUnspecified() {
+			// "0.0.0.0" and "::" are reachable via "127.0.0.1"
+			guest.IP = limayaml.IPv4loopback1
+		}
+		if rule.Ignore {
+			return "", guest.String()
+		}
+		return hostAddress(rule, guest), guest.String()
 	}
+	return "", guest.String()
+}
+
+// PortForwards returns the forwarded ports, sorted by the host address.
+func (pf *portForwarder) PortForwards() []hostagentapi.PortForward {
+	var res []hostagentapi.PortForward
+	pf.tcpMu.RLock()
+	for local, remote := range pf.tcp {
+		res = append(res, hostagentapi.PortForward{
+			Protocol: limayaml.TCP,
+			Guest:    remote,
+			Host:     local,
+		})
+	}
+	pf.tcpMu.RUnlock()
+	pf.udpMu.RLock()
+	for local, f := range pf.udp {
+		res = append(res, hostagentapi.PortForward{
+			Protocol: limayaml.UDP,
+			Guest:    f.remote,
+			Host:     local,
+		})
+	}
+	pf.udpMu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Host != res[j].Host {
+			return res[i].Host < res[j].Host
+		}
+		return res[i].Protocol < res[j].Protocol
+	})
+	return res
+}
+
+func (pf *portForwarder) OnEvent(ctx context.Context, ev api.Event) {
+	var tcpRemoved, tcpAdded, udpRemoved, udpAdded []api.IPPort
 	for _, f := range ev.LocalPortsRemoved {
-		if ignore(f) {
+		if f.ProtocolOrDefault() == api.UDP {
+			udpRemoved = append(udpRemoved, f)
+		} else {
+			tcpRemoved = append(tcpRemoved, f)
+		}
+	}
+	for _, f := range ev.LocalPortsAdded {
+		if f.ProtocolOrDefault() == api.UDP {
+			udpAdded = append(udpAdded, f)
+		} else {
+			tcpAdded = append(tcpAdded, f)
+		}
+	}
+	pf.onTCPEvent(ctx, tcpRemoved, tcpAdded)
+	pf.onUDPEvent(udpRemoved, udpAdded)
+}
+
+func (pf *portForwarder) onTCPEvent(ctx context.Context, removed, added []api.IPPort) {
+	pf.tcpMu.Lock()
+	defer pf.tcpMu.Unlock()
+	for _, f := range removed {
+		local, remote := pf.forwardingAddresses(f)
+		if local == "" {
 			continue
 		}
 		// pf.tcp might be inconsistent with the actual state of the SSH master,
-		// so we always attempt to cancel forwarding, even when f.Port is not tracked in pf.tcp.
-		pf.l.Infof("Stopping forwarding TCP port %d", f.Port)
+		// so we always attempt to cancel forwarding, even when local is not tracked in pf.tcp.
+		pf.l.Infof("Stopping forwarding TCP from %s to %s", remote, local)
 		verbCancel := true
-		if err := forwardSSH(ctx, pf.sshConfig, pf.sshHostPort, "127.0.0.1:"+strconv.Itoa(f.Port), "127.0.0.1:"+strconv.Itoa(f.Port), verbCancel); err != nil {
-			if _, ok := pf.tcp[f.Port]; ok {
-				pf.l.WithError(err).Warnf("failed to stop forwarding TCP port %d", f.Port)
+		if err := forwardSSH(ctx, pf.sshConfig, pf.sshHostPort, local, remote, verbCancel); err != nil {
+			if _, ok := pf.tcp[local]; ok {
+				pf.l.WithError(err).Warnf("failed to stop forwarding TCP from %s to %s", remote, local)
 			} else {
-				pf.l.WithError(err).Debugf("failed to stop forwarding TCP port %d (negligible)", f.Port)
+				pf.l.WithError(err).Debugf("failed to stop forwarding TCP from %s to %s (negligible)", remote, local)
 			}
 		}
-		delete(pf.tcp, f.Port)
+		delete(pf.tcp, local)
 	}
-	for _, f := range ev.LocalPortsAdded {
-		if ignore(f) {
+	for _, f := range added {
+		local, remote := pf.forwardingAddresses(f)
+		if local == "" {
+			pf.l.Debugf("Not forwarding TCP %s", remote)
 			continue
 		}
-		pf.l.Infof("Forwarding TCP port %d", f.Port)
-		if err := forwardSSH(ctx, pf.sshConfig, pf.sshHostPort, "127.0.0.1:"+strconv.Itoa(f.Port), "127.0.0.1:"+strconv.Itoa(f.Port), false); err != nil {
-			pf.l.WithError(err).Warnf("failed to setting up forward TCP port %d (negligible if already forwarded)", f.Port)
+		pf.l.Infof("Forwarding TCP from %s to %s", remote, local)
+		if err := forwardSSH(ctx, pf.sshConfig, pf.sshHostPort, local, remote, false); err != nil {
+			pf.l.WithError(err).Warnf("failed to setting up forward TCP from %s to %s (negligible if already forwarded)", remote, local)
 		} else {
-			pf.tcp[f.Port] = struct{}{}
+			pf.tcp[local] = remote
+		}
+	}
+}
+
+func (pf *portForwarder) onUDPEvent(removed, added []api.IPPort) {
+	pf.udpMu.Lock()
+	defer pf.udpMu.Unlock()
+	for _, f := range removed {
+		local, remote := pf.forwardingAddresses(f)
+		uf, ok := pf.udp[local]
+		if local == "" || !ok || uf.remote != remote {
+			continue
+		}
+		pf.l.Infof("Stopping forwarding UDP from %s to %s", remote, local)
+		if err := uf.forwarder.Close(); err != nil {
+			pf.l.WithError(err).Warnf("failed to stop forwarding UDP from %s to %s", remote, local)
+		}
+		delete(pf.udp, local)
+	}
+	for _, f := range added {
+		local, remote := pf.forwardingAddresses(f)
+		if local == "" {
+			pf.l.Debugf("Not forwarding UDP %s", remote)
+			continue
+		}
+		if _, ok := pf.udp[local]; ok {
+			pf.l.Debugf("Already forwarding UDP to %s", local)
+			continue
+		}
+		pf.l.Infof("Forwarding UDP from %s to %s", remote, local)
+		dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+			client, err := guestagentclient.NewGuestAgentClient(pf.guestAgentSock)
+			if err != nil {
+				return nil, err
+			}
+			return client.TunnelUDP(ctx, remote)
+		}
+		forwarder, err := udptunnel.NewForwarder(pf.l, local, dial)
+		if err != nil {
+			pf.l.WithError(err).Warnf("failed to setting up forward UDP from %s to %s", remote, local)
+			continue
+		}
+		pf.udp[local] = &udpForward{remote: remote, forwarder: forwarder}
+	}
+}
+
+// Close stops the UDP forwarders.
+// The TCP forwarders are stopped along with the SSH master.
+func (pf *portForwarder) Close() error {
+	pf.udpMu.Lock()
+	defer pf.udpMu.Unlock()
+	for local, uf := range pf.udp {
+		if err := uf.forwarder.Close(); err != nil {
+			pf.l.WithError(err).Warnf("failed to stop forwarding UDP from %s to %s", uf.remote, local)
 		}
+		delete(pf.udp, local)
 	}
+	return nil
 }
@@ -0,0 +1,52 @@
+package hostagent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestForwardingAddresses(t *testing.T) {
+	y := &limayaml.LimaYAML{
+		SSH: limayaml.SSH{LocalPort: 60022},
+		PortForwards: []limayaml.PortForward{
+			{GuestPort: 8080, HostPort: 18080},
+			{GuestPortRange: [2]int{4000, 4999}, HostIP: net.IPv4zero, HostPortRange: [2]int{14000, 14999}},
+			{GuestPort: 8888, Ignore: true},
+			{GuestIP: net.IPv4zero, GuestPort: 9090},
+			{GuestPort: 5353, HostPort: 15353, Proto: limayaml.UDP},
+		},
+	}
+	limayaml.FillDefault(y)
+	pf := newPortForwarder(nil, nil, y.SSH.LocalPort, "", portForwardRules(y))
+
+	testCases := []struct {
+		guest          api.IPPort
+		expectedLocal  string
+		expectedRemote string
+	}{
+		{api.IPPort{IP: net.ParseIP("127.0.0.1"), Port: 8080}, "127.0.0.1:18080", "127.0.0.1:8080"},
+		{api.IPPort{IP: net.IPv4zero, Port: 4001}, "0.0.0.0:14001", "127.0.0.1:4001"},
+		{api.IPPort{IP: net.IPv6loopback, Port: 80}, "127.0.0.1:80", "[::1]:80"},
+		{api.IPPort{IP: net.IPv6zero, Port: 3000}, "127.0.0.1:3000", "127.0.0.1:3000"},
+		{api.IPPort{IP: net.ParseIP("127.0.0.1"), Port: 8888}, "", "127.0.0.1:8888"},
+		{api.IPPort{IP: net.ParseIP("127.0.0.53"), Port: 53}, "", "127.0.0.53:53"},
+		{api.IPPort{IP: net.ParseIP("192.168.5.15"), Port: 9090}, "127.0.0.1:9090", "192.168.5.15:9090"},
+		{api.IPPort{IP: net.IPv4zero, Port: 22}, "", "127.0.0.1:22"},
+		{api.IPPort{IP: net.ParseIP("127.0.0.1"), Port: 60022}, "", "127.0.0.1:60022"},
+		{api.IPPort{IP: net.ParseIP("127.0.0.1"), Port: 5353, Protocol: api.UDP}, "127.0.0.1:15353", "127.0.0.1:5353"},
+		{api.IPPort{IP: net.ParseIP("127.0.0.1"), Port: 5353}, "127.0.0.1:5353", "127.0.0.1:5353"},
+		{api.IPPort{IP: net.IPv4zero, Port: 8080, Protocol: api.UDP}, "127.0.0.1:8080", "127.0.0.1:8080"},
+		{api.IPPort{IP: net.IPv4zero, Port: 22, Protocol: api.UDP}, "", "0.0.0.0:22"},
+		{api.IPPort{IP: net.IPv4zero, Port: 68, Protocol: api.UDP}, "", "0.0.0.0:68"},
+		{api.IPPort{IP: net.IPv4zero, Port: 1024, Protocol: api.UDP}, "127.0.0.1:1024", "127.0.0.1:1024"},
+	}
+	for _, tc := range testCases {
+		local, remote := pf.forwardingAddresses(tc.guest)
+		assert.Equal(t, tc.expectedLocal, local, tc.guest.ProtocolOrDefault()+"/"+tc.guest.String())
+		assert.Equal(t, tc.expectedRemote, remote, tc.guest.ProtocolOrDefault()+"/"+tc.guest.String())
+	}
+}
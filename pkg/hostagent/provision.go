@@ -0,0 +1,136 @@
+package hostagent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	guestagentapi "github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/pkg/errors"
+)
+
+// provisionStallWarningInterval is the interval of warning about the provisioning scripts that make no progress.
+// The interval is reset whenever the status of a script changes, so that a stuck script is reported
+// before `limactl start` times out (10 minutes). Slow scripts are not failed, as they may still succeed.
+const provisionStallWarningInterval = 5 * time.Minute
+
+const provisionDebugHint = `see "/var/log/cloud-init-output.log" in the guest`
+
+// updateProvision records the status of the provisioning scripts reported by the guest agent.
+func (a *HostAgent) updateProvision(xs []guestagentapi.Provision) {
+	if len(xs) == 0 {
+		return
+	}
+	a.provisionMu.Lock()
+	for _, x := range xs {
+		if old, ok := a.provision[x.Name]; ok && old.Status == x.Status {
+			a.provision[x.Name] = x
+			continue
+		}
+		a.provision[x.Name] = x
+		switch x.Status {
+		case guestagentapi.ProvisionRunning:
+			a.l.Infof("Executing the provisioning script %q (mode %q)", x.Name, x.Mode)
+		case guestagentapi.ProvisionSucceeded:
+			a.l.Infof("The provisioning script %q succeeded in %v", x.Name, x.Duration())
+		case guestagentapi.ProvisionFailed:
+			a.l.Errorf("The provisioning script %q failed with exit code %d in %v", x.Name, x.ExitCode, x.Duration())
+		case guestagentapi.ProvisionSkipped:
+			a.l.Infof("The provisioning script %q was skipped", x.Name)
+		}
+	}
+	a.provisionMu.Unlock()
+	select {
+	case a.provisionCh <- struct{}{}:
+	default:
+	}
+}
+
+// waitForProvision waits for the provisioning scripts to finish.
+// waitForProvision returns an error as soon as a script fails, and warns when no script makes progress in provisionStallWarningInterval.
+func (a *HostAgent) waitForProvision(ctx context.Context) error {
+	if len(a.y.Provision) == 0 {
+		return nil
+	}
+	a.l.Infof("Waiting for the %d provisioning scripts", len(a.y.Provision))
+	var lastProgress string
+	timer := time.NewTimer(provisionStallWarningInterval)
+	defer timer.Stop()
+	for {
+		a.provisionMu.Lock()
+		pending, err := checkProvision(a.y.Provision, a.provision)
+		progress := provisionProgress(a.provision)
+		a.provisionMu.Unlock()
+		if err != nil {
+			return errors.Errorf("%v (hint: %s)", err, provisionDebugHint)
+		}
+		if len(pending) == 0 {
+			a.l.Info("All the provisioning scripts have finished")
+			return nil
+		}
+		if progress != lastProgress {
+			lastProgress = progress
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(provisionStallWarningInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			a.l.Warnf("The provisioning scripts %s made no progress in %v, still waiting (hint: %s)", strings.Join(pending, ", "), provisionStallWarningInterval, provisionDebugHint)
+			timer.Reset(provisionStallWarningInterval)
+		case <-a.provisionCh:
+		}
+	}
+}
+
+// provisionProgress returns a string that changes whenever the status of a script changes.
+func provisionProgress(reported map[string]guestagentapi.Provision) string {
+	var names []string
+	for name := range reported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var progress strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&progress, "%s=%s\n", name, reported[name].Status)
+	}
+	return progress.String()
+}
+
+// checkProvision returns the names of the provisioning scripts that have not finished yet,
+// or an error if a script failed or is unknown to the guest.
+func checkProvision(provision []limayaml.Provision, reported map[string]guestagentapi.Provision) ([]string, error) {
+	order, err := limayaml.ProvisionOrder(provision)
+	if err != nil {
+		return nil, err
+	}
+	// The guest reports all the scripts as "pending" before executing any of them,
+	// so the scripts that are not reported after all the reported ones have finished are unknown to the guest.
+	allFinished := len(reported) > 0
+	for _, x := range reported {
+		if !x.Finished() {
+			allFinished = false
+			break
+		}
+	}
+	var pending []string
+	for _, i := range order {
+		p := provision[i]
+		x, ok := reported[p.Name]
+		switch {
+		case !ok && allFinished:
+			return nil, errors.Errorf("the provisioning script %q (mode %q) was not executed, as it is unknown to the guest", p.Name, p.Mode)
+		case !ok || !x.Finished():
+			pending = append(pending, p.Name)
+		case x.Status == guestagentapi.ProvisionFailed:
+			return nil, errors.Errorf("the provisioning script %q (mode %q) failed with exit code %d", p.Name, p.Mode, x.ExitCode)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,48 @@
+package hostagent
+
+import (
+	"testing"
+
+	guestagentapi "github.com/AkihiroSuda/lima/pkg/guestagent/api"
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckProvision(t *testing.T) {
+	provision := []limayaml.Provision{
+		{Mode: limayaml.ProvisionModeSystem, Name: "vim"},
+		{Mode: limayaml.ProvisionModeUser, Name: "dotfiles", After: []string{"vim"}},
+		{Mode: limayaml.ProvisionModeDependency, Name: "apt-mirror"},
+	}
+	reported := make(map[string]guestagentapi.Provision)
+	pending, err := checkProvision(provision, reported)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"apt-mirror", "vim", "dotfiles"}, pending)
+
+	for _, p := range provision {
+		reported[p.Name] = guestagentapi.Provision{Name: p.Name, Mode: p.Mode, Status: guestagentapi.ProvisionPending}
+	}
+	reported["apt-mirror"] = guestagentapi.Provision{Name: "apt-mirror", Status: guestagentapi.ProvisionSucceeded}
+	progress := provisionProgress(reported)
+	reported["vim"] = guestagentapi.Provision{Name: "vim", Status: guestagentapi.ProvisionRunning}
+	assert.Assert(t, progress != provisionProgress(reported))
+	pending, err = checkProvision(provision, reported)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"vim", "dotfiles"}, pending)
+
+	// Fail fast, without waiting for "dotfiles"
+	reported["vim"] = guestagentapi.Provision{Name: "vim", Status: guestagentapi.ProvisionFailed, ExitCode: 100}
+	_, err = checkProvision(provision, reported)
+	assert.ErrorContains(t, err, "\"vim\" (mode \"system\") failed with exit code 100")
+
+	reported["vim"] = guestagentapi.Provision{Name: "vim", Status: guestagentapi.ProvisionSucceeded}
+	reported["dotfiles"] = guestagentapi.Provision{Name: "dotfiles", Status: guestagentapi.ProvisionSkipped}
+	pending, err = checkProvision(provision, reported)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(pending))
+
+	// A script added after the cidata was applied
+	provision = append(provision, limayaml.Provision{Mode: limayaml.ProvisionModeSystem, Name: "motd"})
+	_, err = checkProvision(provision, reported)
+	assert.ErrorContains(t, err, "unknown to the guest")
+}
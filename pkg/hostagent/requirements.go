@@ -2,6 +2,8 @@ package hostagent
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
@@ -73,7 +75,7 @@ Make sure that the YAML field "ssh.localPort" is not used by other processes on
 If any private key under ~/.ssh is protected with a passphrase, you need to have ssh-agent to be running.
 `,
 	})
-	if len(a.y.Mounts) > 0 {
+	if hasMountType(a.y, limayaml.ReverseSSHFS) {
 		req = append(req, requirement{
 			description: "sshfs binary to be installed",
 			script: `#!/bin/bash
@@ -100,7 +102,29 @@ fi
 `,
 			debugHint: `Append "user_allow_other" to /etc/fuse.conf in the guest`,
 		})
-
+	}
+	if guestMountPoints := guestMountPoints(a.y); len(guestMountPoints) > 0 {
+		var quoted []string
+		for _, f := range guestMountPoints {
+			quoted = append(quoted, shellQuote(f))
+		}
+		req = append(req, requirement{
+			description: "9p and virtiofs mounts to be mounted",
+			script: fmt.Sprintf(`#!/bin/bash
+set -eux -o pipefail
+for f in %s; do
+	if ! timeout 30s bash -c 'until mountpoint -q "$1"; do sleep 3; done' bash "${f}"; then
+		echo >&2 "${f} is not mounted yet"
+		exit 1
+	fi
+done
+`, strings.Join(quoted, " ")),
+			debugHint: `The 9p or virtiofs mounts were not mounted in the guest.
+Make sure that the guest kernel supports 9p (CONFIG_NET_9P_VIRTIO) or virtiofs (CONFIG_VIRTIO_FS).
+For virtiofs, make sure that virtiofsd is running on the host (see "ha.stderr.log").
+Also see "/var/log/cloud-init-output.log" in the guest.
+`,
+		})
 	}
 	req = append(req, requirement{
 		description: "the guest agent to be running",
@@ -167,3 +191,28 @@ Also see "/var/log/cloud-init-output.log" in the guest.
 	}
 	return req
 }
+
+func hasMountType(y *limayaml.LimaYAML, t limayaml.MountType) bool {
+	for _, m := range y.Mounts {
+		if m.MountType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// guestMountPoints returns the mount points of the mounts that are mounted by the guest (9p and virtiofs).
+func guestMountPoints(y *limayaml.LimaYAML) []string {
+	var res []string
+	for _, m := range y.Mounts {
+		if m.MountType == limayaml.ReverseSSHFS {
+			continue
+		}
+		res = append(res, m.MountPoint)
+	}
+	return res
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
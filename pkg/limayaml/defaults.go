@@ -2,9 +2,15 @@ package limayaml
 
 import (
 	"fmt"
+	"net"
 	"runtime"
+
+	"github.com/AkihiroSuda/lima/pkg/localpathutil"
 )
 
+// IPv4loopback1 is 127.0.0.1, the default value of PortForward.GuestIP and PortForward.HostIP
+var IPv4loopback1 = net.IPv4(127, 0, 0, 1)
+
 func FillDefault(y *LimaYAML) {
 	y.Arch = resolveArch(y.Arch)
 	for i := range y.Images {
@@ -22,6 +28,21 @@ func FillDefault(y *LimaYAML) {
 	if y.Disk == "" {
 		y.Disk = "100GiB"
 	}
+	if y.MountType == "" {
+		y.MountType = ReverseSSHFS
+	}
+	for i := range y.Mounts {
+		FillMountDefaults(&y.Mounts[i], y.MountType)
+	}
+	if len(y.Networks) == 0 {
+		y.Networks = []Network{{Type: NetworkUser}}
+	}
+	if y.HostResolver.Enabled == nil {
+		y.HostResolver.Enabled = &[]bool{false}[0]
+	}
+	if y.Proxy.FromEnv == nil {
+		y.Proxy.FromEnv = &[]bool{true}[0]
+	}
 	if y.Video.Display == "" {
 		y.Video.Display = "none"
 	}
@@ -30,6 +51,9 @@ func FillDefault(y *LimaYAML) {
 		if provision.Mode == "" {
 			provision.Mode = ProvisionModeSystem
 		}
+		if provision.Name == "" {
+			provision.Name = fmt.Sprintf("provision-%d", i)
+		}
 	}
 	if y.Containerd.System == nil {
 		y.Containerd.System = &[]bool{false}[0]
@@ -46,6 +70,58 @@ func FillDefault(y *LimaYAML) {
 			probe.Description = fmt.Sprintf("user probe %d/%d", i+1, len(y.Probes))
 		}
 	}
+	for i := range y.PortForwards {
+		FillPortForwardDefaults(&y.PortForwards[i])
+	}
+}
+
+// FillMountDefaults fills the unspecified fields of the mount.
+// mountType is the default mount type (LimaYAML.MountType).
+func FillMountDefaults(mount *Mount, mountType MountType) {
+	if mount.MountType == "" {
+		mount.MountType = mountType
+	}
+	if mount.MountPoint == "" {
+		// An unexpandable location is rejected by Validate
+		if expanded, err := localpathutil.Expand(mount.Location); err == nil {
+			mount.MountPoint = expanded
+		}
+	}
+	if mount.SSHFS.Cache == nil {
+		mount.SSHFS.Cache = &[]bool{true}[0]
+	}
+	if mount.SSHFS.FollowSymlinks == nil {
+		mount.SSHFS.FollowSymlinks = &[]bool{false}[0]
+	}
+}
+
+func FillPortForwardDefaults(rule *PortForward) {
+	if rule.Proto == "" {
+		rule.Proto = TCP
+	}
+	if rule.GuestIP == nil {
+		rule.GuestIP = IPv4loopback1
+	}
+	if rule.HostIP == nil {
+		rule.HostIP = IPv4loopback1
+	}
+	if rule.GuestPortRange[0] == 0 && rule.GuestPortRange[1] == 0 {
+		if rule.GuestPort == 0 {
+			rule.GuestPortRange[0] = 1
+			rule.GuestPortRange[1] = 65535
+		} else {
+			rule.GuestPortRange[0] = rule.GuestPort
+			rule.GuestPortRange[1] = rule.GuestPort
+		}
+	}
+	if rule.HostPortRange[0] == 0 && rule.HostPortRange[1] == 0 {
+		if rule.HostPort == 0 {
+			rule.HostPortRange = rule.GuestPortRange
+		} else {
+			rule.HostPortRange[0] = rule.HostPort
+			rule.HostPortRange[1] = rule.HostPort
+		}
+	}
 }
 
 func resolveArch(s string) Arch {
@@ -0,0 +1,122 @@
+package limayaml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+type ChangeClass = string
+
+const (
+	// ChangeLive can be applied to a running instance
+	ChangeLive ChangeClass = "live"
+	// ChangeRestart is applied on the next start of the instance
+	ChangeRestart ChangeClass = "restart"
+	// ChangeRejected cannot be applied to an existing instance
+	ChangeRejected ChangeClass = "rejected"
+)
+
+// Change is a change between two yamls.
+type Change struct {
+	Field       string // e.g., "cpus", "mounts[/tmp/lima]"
+	Description string // e.g., "4 -> 2"
+	Class       ChangeClass
+	// AddedMount and RemovedMount are set for the ChangeLive changes of the mounts
+	AddedMount   *Mount
+	RemovedMount *Mount
+}
+
+// Diff returns the changes from oldY to newY.
+// Both oldY and newY have to be filled with the default values (see FillDefault).
+func Diff(oldY, newY *LimaYAML) []Change {
+	var res []Change
+	oldV, newV := reflect.ValueOf(*oldY), reflect.ValueOf(*newY)
+	t := oldV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := yamlFieldName(t.Field(i))
+		oldF, newF := oldV.Field(i).Interface(), newV.Field(i).Interface()
+		if reflect.DeepEqual(oldF, newF) {
+			continue
+		}
+		switch field {
+		case "mounts":
+			res = append(res, diffMounts(oldY.Mounts, newY.Mounts)...)
+			continue
+		case "arch":
+			res = append(res, Change{Field: field, Description: describe(oldF, newF) + " (the architecture cannot be changed)", Class: ChangeRejected})
+			continue
+		case "images":
+			res = append(res, Change{Field: field, Description: "modified (the images are only used for creating a new instance)", Class: ChangeRejected})
+			continue
+		case "disk":
+			oldSize, _ := units.RAMInBytes(oldY.Disk)
+			newSize, _ := units.RAMInBytes(newY.Disk)
+			switch {
+			case newSize < oldSize:
+				res = append(res, Change{Field: field, Description: describe(oldF, newF) + " (the disk cannot be shrunk)", Class: ChangeRejected})
+			case newSize > oldSize:
+				res = append(res, Change{Field: field, Description: describe(oldF, newF), Class: ChangeRestart})
+			}
+			continue
+		}
+		res = append(res, Change{Field: field, Description: describe(oldF, newF), Class: ChangeRestart})
+	}
+	return res
+}
+
+// diffMounts compares the mounts by their mount points.
+// Adding and removing reverse-sshfs mounts can be applied to a running instance.
+func diffMounts(oldMounts, newMounts []Mount) []Change {
+	var res []Change
+	newByMountPoint := make(map[string]Mount)
+	for _, m := range newMounts {
+		newByMountPoint[m.MountPoint] = m
+	}
+	oldByMountPoint := make(map[string]Mount)
+	for _, m := range oldMounts {
+		m := m
+		oldByMountPoint[m.MountPoint] = m
+		field := fmt.Sprintf("mounts[%s]", m.MountPoint)
+		newM, ok := newByMountPoint[m.MountPoint]
+		switch {
+		case !ok && m.MountType == ReverseSSHFS:
+			res = append(res, Change{Field: field, Description: "removed", Class: ChangeLive, RemovedMount: &m})
+		case !ok:
+			res = append(res, Change{Field: field, Description: "removed", Class: ChangeRestart})
+		case !reflect.DeepEqual(m, newM):
+			res = append(res, Change{Field: field, Description: "modified", Class: ChangeRestart})
+		}
+	}
+	for _, m := range newMounts {
+		m := m
+		if _, ok := oldByMountPoint[m.MountPoint]; ok {
+			continue
+		}
+		field := fmt.Sprintf("mounts[%s]", m.MountPoint)
+		if m.MountType == ReverseSSHFS {
+			res = append(res, Change{Field: field, Description: "added", Class: ChangeLive, AddedMount: &m})
+		} else {
+			res = append(res, Change{Field: field, Description: "added", Class: ChangeRestart})
+		}
+	}
+	return res
+}
+
+func yamlFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func describe(oldV, newV interface{}) string {
+	switch reflect.ValueOf(oldV).Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return fmt.Sprintf("%v -> %v", oldV, newV)
+	}
+	return "modified"
+}
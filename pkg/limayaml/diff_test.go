@@ -0,0 +1,53 @@
+package limayaml
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDiff(t *testing.T) {
+	load := func(s string) *LimaYAML {
+		y, err := Load([]byte(s))
+		assert.NilError(t, err)
+		return y
+	}
+	const base = `images:
+- location: https://example.com/foo.img
+cpus: 2
+disk: 100GiB
+mounts:
+- location: /tmp/foo
+- location: /tmp/bar
+  mountType: 9p
+`
+	assert.Equal(t, 0, len(Diff(load(base), load(base))))
+
+	changes := Diff(load(base), load(`images:
+- location: https://example.com/foo.img
+cpus: 4
+disk: 50GiB
+mounts:
+- location: /tmp/baz
+- location: /tmp/bar
+  mountType: 9p
+  writable: true
+`))
+	classes := make(map[string]ChangeClass)
+	for _, c := range changes {
+		classes[c.Field] = c.Class
+	}
+	assert.DeepEqual(t, map[string]ChangeClass{
+		"cpus":             ChangeRestart,
+		"disk":             ChangeRejected,
+		"mounts[/tmp/foo]": ChangeLive,
+		"mounts[/tmp/bar]": ChangeRestart,
+		"mounts[/tmp/baz]": ChangeLive,
+	}, classes)
+
+	changes = Diff(load(base), load(strings.Replace(base, "disk: 100GiB", "disk: 200GiB", 1)))
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "disk", changes[0].Field)
+	assert.Equal(t, ChangeRestart, changes[0].Class)
+}
@@ -1,18 +1,30 @@
 package limayaml
 
+import (
+	"net"
+)
+
 type LimaYAML struct {
-	Arch       Arch        `yaml:"arch,omitempty"`
-	Images     []Image     `yaml:"images"` // REQUIRED
-	CPUs       int         `yaml:"cpus,omitempty"`
-	Memory     string      `yaml:"memory,omitempty"` // go-units.RAMInBytes
-	Disk       string      `yaml:"disk,omitempty"`   // go-units.RAMInBytes
-	Mounts     []Mount     `yaml:"mounts,omitempty"`
-	SSH        SSH         `yaml:"ssh,omitempty"` // REQUIRED (FIXME)
-	Firmware   Firmware    `yaml:"firmware,omitempty"`
-	Video      Video       `yaml:"video,omitempty"`
-	Provision  []Provision `yaml:"provision,omitempty"`
-	Containerd Containerd  `yaml:"containerd,omitempty"`
-	Probes     []Probe     `yaml:"probes,omitempty"`
+	Arch            Arch              `yaml:"arch,omitempty"`
+	Images          []Image           `yaml:"images"` // REQUIRED
+	CPUs            int               `yaml:"cpus,omitempty"`
+	Memory          string            `yaml:"memory,omitempty"`          // go-units.RAMInBytes
+	Disk            string            `yaml:"disk,omitempty"`            // go-units.RAMInBytes
+	AdditionalDisks []string          `yaml:"additionalDisks,omitempty"` // names of the disks under ~/.lima/_disks
+	Mounts          []Mount           `yaml:"mounts,omitempty"`
+	MountType       MountType         `yaml:"mountType,omitempty"` // default: "reverse-sshfs"
+	Networks        []Network         `yaml:"networks,omitempty"`  // default: [{type: user}]
+	DNS             DNS               `yaml:"dns,omitempty"`
+	HostResolver    HostResolver      `yaml:"hostResolver,omitempty"`
+	Proxy           Proxy             `yaml:"proxy,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty"` // written to /etc/environment of the guest
+	SSH             SSH               `yaml:"ssh,omitempty"`
+	Firmware        Firmware          `yaml:"firmware,omitempty"`
+	Video           Video             `yaml:"video,omitempty"`
+	Provision       []Provision       `yaml:"provision,omitempty"`
+	Containerd      Containerd        `yaml:"containerd,omitempty"`
+	Probes          []Probe           `yaml:"probes,omitempty"`
+	PortForwards    []PortForward     `yaml:"portForwards,omitempty"`
 }
 
 type Arch = string
@@ -25,15 +37,75 @@ const (
 type Image struct {
 	Location string `yaml:"location"` // REQUIRED
 	Arch     string `yaml:"arch,omitempty"`
+	Digest   string `yaml:"digest,omitempty"` // "sha256:..." or "sha512:..."
 }
 
 type Mount struct {
-	Location string `yaml:"location"` // REQUIRED
-	Writable bool   `yaml:"writable,omitempty"`
+	Location   string    `yaml:"location"`             // REQUIRED
+	MountPoint string    `yaml:"mountPoint,omitempty"` // default: Location (expanded)
+	Writable   bool      `yaml:"writable,omitempty"`
+	MountType  MountType `yaml:"mountType,omitempty"` // default: LimaYAML.MountType
+	SSHFS      SSHFS     `yaml:"sshfs,omitempty"`
+	UID        *int      `yaml:"uid,omitempty"`   // reverse-sshfs only
+	GID        *int      `yaml:"gid,omitempty"`   // reverse-sshfs only
+	Umask      string    `yaml:"umask,omitempty"` // octal, e.g., "022"; reverse-sshfs only
+}
+
+// SSHFS is the set of options for reverse-sshfs mounts.
+type SSHFS struct {
+	Cache          *bool `yaml:"cache,omitempty"`          // default: true
+	FollowSymlinks *bool `yaml:"followSymlinks,omitempty"` // default: false
+}
+
+type MountType = string
+
+const (
+	ReverseSSHFS MountType = "reverse-sshfs"
+	NineP        MountType = "9p"
+	VirtIOFS     MountType = "virtiofs"
+)
+
+type NetworkType = string
+
+const (
+	NetworkUser   NetworkType = "user"
+	NetworkTap    NetworkType = "tap"
+	NetworkBridge NetworkType = "bridge"
+	NetworkVDE    NetworkType = "vde"
+	NetworkLima   NetworkType = "lima"
+)
+
+type Network struct {
+	Type       NetworkType `yaml:"type"`                 // REQUIRED
+	Interface  string      `yaml:"interface,omitempty"`  // tap device (tap), or bridge (bridge)
+	Switch     string      `yaml:"switch,omitempty"`     // vde_switch socket (vde)
+	Network    string      `yaml:"network,omitempty"`    // network created with `limactl network create` (lima)
+	MACAddress string      `yaml:"macAddress,omitempty"` // default: generated from the instance name
+}
+
+type DNS struct {
+	Servers []net.IP          `yaml:"servers,omitempty"` // default: the DNS server of the user network (192.168.5.3)
+	Search  []string          `yaml:"search,omitempty"`
+	Hosts   map[string]net.IP `yaml:"hosts,omitempty"` // static entries of /etc/hosts
+}
+
+// HostResolver is the DNS forwarder in the host agent.
+// When enabled, the queries to 192.168.5.3 are resolved by the resolver of the host.
+type HostResolver struct {
+	Enabled *bool `yaml:"enabled,omitempty"` // default: false
+}
+
+// Proxy is the HTTP(S) proxy of the guest.
+// The loopback addresses of the host (e.g., "http://127.0.0.1:3128") are rewritten to the host address in the user network.
+type Proxy struct {
+	FromEnv *bool    `yaml:"fromEnv,omitempty"` // default: true (fill the empty fields from $http_proxy, $https_proxy, and $no_proxy of the host)
+	HTTP    string   `yaml:"http,omitempty"`    // e.g., "http://proxy.example.com:3128"
+	HTTPS   string   `yaml:"https,omitempty"`
+	NoProxy []string `yaml:"noProxy,omitempty"`
 }
 
 type SSH struct {
-	LocalPort int `yaml:"localPort,omitempty"` // REQUIRED (FIXME: auto assign)
+	LocalPort int `yaml:"localPort,omitempty"` // default: 0 (automatically assigned on start)
 }
 
 type Firmware struct {
@@ -50,13 +122,19 @@ type Video struct {
 type ProvisionMode = string
 
 const (
-	ProvisionModeSystem ProvisionMode = "system"
-	ProvisionModeUser   ProvisionMode = "user"
+	ProvisionModeSystem     ProvisionMode = "system"
+	ProvisionModeUser       ProvisionMode = "user"
+	ProvisionModeDependency ProvisionMode = "dependency" // executed as root, before the "system" and "user" scripts
 )
 
 type Provision struct {
-	Mode   ProvisionMode `yaml:"mode"` // default: "system"
-	Script string        `yaml:"script"`
+	Mode ProvisionMode `yaml:"mode"`           // default: "system"
+	Name string        `yaml:"name,omitempty"` // default: "provision-<INDEX>"
+	// After is the list of the names of the scripts that have to succeed before executing this script
+	After []string `yaml:"after,omitempty"`
+	// RunOnce executes the script only on the first boot of the instance (cloud-init per-instance semantics)
+	RunOnce bool   `yaml:"runOnce,omitempty"`
+	Script  string `yaml:"script"`
 }
 
 type Containerd struct {
@@ -76,3 +154,21 @@ type Probe struct {
 	Script      string
 	Hint        string
 }
+
+type Proto = string
+
+const (
+	TCP Proto = "tcp"
+	UDP Proto = "udp"
+)
+
+type PortForward struct {
+	GuestIP        net.IP `yaml:"guestIP,omitempty"`   // default: 127.0.0.1
+	GuestPort      int    `yaml:"guestPort,omitempty"` // default: 0 (all the ports)
+	GuestPortRange [2]int `yaml:"guestPortRange,omitempty"`
+	HostIP         net.IP `yaml:"hostIP,omitempty"`   // default: 127.0.0.1
+	HostPort       int    `yaml:"hostPort,omitempty"` // default: same as the guest port
+	HostPortRange  [2]int `yaml:"hostPortRange,omitempty"`
+	Proto          Proto  `yaml:"proto,omitempty"` // default: "tcp" ("tcp" or "udp")
+	Ignore         bool   `yaml:"ignore,omitempty"`
+}
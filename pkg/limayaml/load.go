@@ -15,3 +15,13 @@ func Load(b []byte) (*LimaYAML, error) {
 	FillDefault(&y)
 	return &y, nil
 }
+
+// LoadRaw loads the yaml without filling the default values.
+// LoadRaw is useful for modifying the yaml without expanding the defaults into the file.
+func LoadRaw(b []byte) (*LimaYAML, error) {
+	var y LimaYAML
+	if err := yaml.Unmarshal(b, &y); err != nil {
+		return nil, err
+	}
+	return &y, nil
+}
@@ -0,0 +1,74 @@
+package limayaml
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ReplaceField replaces the top-level field key of the YAML document b with v.
+// The field is removed when v is nil, and appended when the field does not exist yet.
+//
+// The rest of the document is preserved as-is, including the comments.
+// The comments inside the replaced field are not preserved.
+func ReplaceField(b []byte, key string, v interface{}) ([]byte, error) {
+	// yaml.v3 is only used for the line numbers of the fields
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	start, end := -1, len(lines)
+	if len(doc.Content) > 0 {
+		m := doc.Content[0]
+		if m.Kind != yamlv3.MappingNode {
+			return nil, errors.New("expected the YAML document to be a mapping")
+		}
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			if start >= 0 {
+				end = m.Content[i].Line - 1
+				break
+			}
+			if m.Content[i].Value == key {
+				start = m.Content[i].Line - 1
+			}
+		}
+	}
+	if start >= 0 {
+		// The blank lines and the unindented comments at the end belong to the next field
+		for end > start+1 && isBlankOrUnindentedComment(lines[end-1]) {
+			end--
+		}
+	}
+
+	var field string
+	if v != nil {
+		// v is marshalled with yaml.v2, as the types of this package are designed for yaml.v2
+		fb, err := yaml.Marshal(yaml.MapSlice{{Key: key, Value: v}})
+		if err != nil {
+			return nil, err
+		}
+		field = string(fb)
+	}
+	var res string
+	if start >= 0 {
+		res = strings.Join(lines[:start], "") + field + strings.Join(lines[end:], "")
+	} else {
+		res = string(b)
+		if res != "" && !strings.HasSuffix(res, "\n") {
+			res += "\n"
+		}
+		res += field
+	}
+	var check yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(res), &check); err != nil {
+		return nil, errors.Wrapf(err, "failed to replace the field %q", key)
+	}
+	return []byte(res), nil
+}
+
+func isBlankOrUnindentedComment(line string) bool {
+	return strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#")
+}
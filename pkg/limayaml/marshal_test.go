@@ -0,0 +1,45 @@
+package limayaml
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestReplaceField(t *testing.T) {
+	const orig = `# comment
+arch: x86_64
+mounts:
+- location: /foo
+  # comment inside the field
+  writable: false
+
+# comment of cpus
+cpus: 2 # line comment
+`
+	mounts := []Mount{{Location: "/foo"}, {Location: "/bar", Writable: true}}
+	b, err := ReplaceField([]byte(orig), "mounts", mounts)
+	assert.NilError(t, err)
+	assert.Equal(t, `# comment
+arch: x86_64
+mounts:
+- location: /foo
+- location: /bar
+  writable: true
+
+# comment of cpus
+cpus: 2 # line comment
+`, string(b))
+
+	b, err = ReplaceField(b, "mounts", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, "# comment\narch: x86_64\n\n# comment of cpus\ncpus: 2 # line comment\n", string(b))
+
+	b, err = ReplaceField(b, "mounts", mounts[:1])
+	assert.NilError(t, err)
+	assert.Equal(t, "# comment\narch: x86_64\n\n# comment of cpus\ncpus: 2 # line comment\nmounts:\n- location: /foo\n", string(b))
+
+	b, err = ReplaceField([]byte("cpus: 2"), "disk", "200GiB")
+	assert.NilError(t, err)
+	assert.Equal(t, "cpus: 2\ndisk: 200GiB\n", string(b))
+}
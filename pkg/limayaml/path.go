@@ -0,0 +1,67 @@
+package limayaml
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AkihiroSuda/lima/pkg/localpathutil"
+	"github.com/pkg/errors"
+)
+
+// ErrNotMounted is returned by GuestPath when the host path is not under any mount.
+var ErrNotMounted = errors.New("not mounted in the guest")
+
+// GuestPath translates the host path to the guest path, using the mounts.
+// The mounts need to be filled with FillDefault (or FillMountDefaults).
+// When the host path is under multiple mounts, the most specific (longest) mount is used.
+//
+// GuestPath returns an error that wraps ErrNotMounted when the host path is not under any mount.
+func GuestPath(mounts []Mount, hostPath string) (string, error) {
+	abs, err := filepath.Abs(hostPath)
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{abs}
+	// e.g., "/tmp" is a symlink to "/private/tmp" on macOS
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil && resolved != abs {
+		candidates = append(candidates, resolved)
+	}
+	var (
+		guestPath   string
+		longestHost string
+	)
+	for _, m := range mounts {
+		location, err := localpathutil.Expand(m.Location)
+		if err != nil || m.MountPoint == "" {
+			continue
+		}
+		locations := []string{location}
+		if resolved, err := filepath.EvalSymlinks(location); err == nil && resolved != location {
+			locations = append(locations, resolved)
+		}
+		for _, loc := range locations {
+			for _, p := range candidates {
+				rel, ok := relPath(loc, p)
+				if !ok || len(loc) <= len(longestHost) {
+					continue
+				}
+				longestHost = loc
+				guestPath = path.Join(m.MountPoint, filepath.ToSlash(rel))
+			}
+		}
+	}
+	if guestPath == "" {
+		return "", errors.Wrapf(ErrNotMounted, "host path %q", hostPath)
+	}
+	return guestPath, nil
+}
+
+// relPath returns the relative path of p from base, if p is base or under base.
+func relPath(base, p string) (string, bool) {
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,50 @@
+package limayaml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestGuestPath(t *testing.T) {
+	homeDir, err := os.UserHomeDir()
+	assert.NilError(t, err)
+	tmpDir := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(tmpDir, "foo"), 0755))
+	assert.NilError(t, os.Symlink(tmpDir, filepath.Join(tmpDir, "..", filepath.Base(tmpDir)+"-symlink")))
+	defer os.Remove(tmpDir + "-symlink")
+
+	mounts := []Mount{
+		{Location: "~"},
+		{Location: "~/work", MountPoint: "/work"},
+		{Location: tmpDir, MountPoint: "/mnt/tmp"},
+	}
+	for i := range mounts {
+		FillMountDefaults(&mounts[i], ReverseSSHFS)
+	}
+	testCases := []struct {
+		hostPath  string
+		guestPath string
+	}{
+		{homeDir, homeDir},
+		{filepath.Join(homeDir, "foo"), filepath.Join(homeDir, "foo")},
+		{filepath.Join(homeDir, "work"), "/work"},
+		{filepath.Join(homeDir, "work", "foo", "bar"), "/work/foo/bar"},
+		{filepath.Join(homeDir, "workspace"), filepath.Join(homeDir, "workspace")},
+		{filepath.Join(tmpDir, "foo"), "/mnt/tmp/foo"},
+		{filepath.Join(tmpDir+"-symlink", "foo"), "/mnt/tmp/foo"},
+	}
+	for _, tc := range testCases {
+		guestPath, err := GuestPath(mounts, tc.hostPath)
+		assert.NilError(t, err, tc.hostPath)
+		assert.Equal(t, tc.guestPath, guestPath, tc.hostPath)
+	}
+
+	_, err = GuestPath(mounts, "/")
+	assert.Assert(t, errors.Is(err, ErrNotMounted), err)
+	_, err = GuestPath(nil, homeDir)
+	assert.Assert(t, errors.Is(err, ErrNotMounted), err)
+}
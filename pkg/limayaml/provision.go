@@ -0,0 +1,91 @@
+package limayaml
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// provisionNameRegexp is the pattern of Provision.Name.
+// The name is used as a file name in the guest.
+var provisionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func validateProvision(provision []Provision) error {
+	names := make(map[string]int, len(provision))
+	for i, p := range provision {
+		switch p.Mode {
+		case ProvisionModeSystem, ProvisionModeUser, ProvisionModeDependency:
+		default:
+			return errors.Errorf("field `provision[%d].mode` must be %q, %q, or %q",
+				i, ProvisionModeSystem, ProvisionModeUser, ProvisionModeDependency)
+		}
+		if !provisionNameRegexp.MatchString(p.Name) {
+			return errors.Errorf("field `provision[%d].name` must match %q, got %q", i, provisionNameRegexp.String(), p.Name)
+		}
+		if j, ok := names[p.Name]; ok {
+			return errors.Errorf("field `provision[%d].name` duplicates `provision[%d].name` (%q)", i, j, p.Name)
+		}
+		names[p.Name] = i
+	}
+	for i, p := range provision {
+		for _, after := range p.After {
+			j, ok := names[after]
+			if !ok {
+				return errors.Errorf("field `provision[%d].after` refers to an unknown script %q", i, after)
+			}
+			if p.Mode == ProvisionModeDependency && provision[j].Mode != ProvisionModeDependency {
+				return errors.Errorf("field `provision[%d].after` refers to a script of mode %q (%q); a script of mode %q can only be after the scripts of mode %q",
+					i, provision[j].Mode, after, ProvisionModeDependency, ProvisionModeDependency)
+			}
+		}
+	}
+	_, err := ProvisionOrder(provision)
+	return err
+}
+
+// ProvisionOrder returns the indices of the provisioning scripts in the execution order.
+// The scripts of mode "dependency" are executed first, and a script is executed after the scripts in its `after` field.
+// Otherwise the scripts are executed in the order of the yaml.
+//
+// ProvisionOrder returns an error if the `after` fields form a cycle or refer to unknown scripts.
+func ProvisionOrder(provision []Provision) ([]int, error) {
+	order := make([]int, 0, len(provision))
+	done := make(map[string]bool, len(provision))
+	scheduled := make([]bool, len(provision))
+	ready := func(p Provision) bool {
+		for _, after := range p.After {
+			if !done[after] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, dependencyPhase := range []bool{true, false} {
+		for progress := true; progress; {
+			progress = false
+			for i, p := range provision {
+				if scheduled[i] || (p.Mode == ProvisionModeDependency) != dependencyPhase || !ready(p) {
+					continue
+				}
+				order = append(order, i)
+				scheduled[i] = true
+				done[p.Name] = true
+				progress = true
+				// Restart from the top, so that the order of the yaml is kept as possible
+				break
+			}
+		}
+	}
+	if len(order) != len(provision) {
+		var pending []string
+		for i, p := range provision {
+			if !scheduled[i] {
+				pending = append(pending, p.Name)
+			}
+		}
+		return nil, errors.Errorf("field `provision` has unresolvable `after` dependencies (a cycle, or a reference to an unknown script): %s",
+			strings.Join(pending, ", "))
+	}
+	return order, nil
+}
@@ -0,0 +1,41 @@
+package limayaml
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestProvisionOrder(t *testing.T) {
+	provision := []Provision{
+		{Mode: ProvisionModeUser, Name: "dotfiles", After: []string{"vim"}},
+		{Mode: ProvisionModeSystem, Name: "vim"},
+		{Mode: ProvisionModeDependency, Name: "apt-mirror"},
+		{Mode: ProvisionModeSystem, Name: "motd"},
+	}
+	order, err := ProvisionOrder(provision)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []int{2, 1, 0, 3}, order)
+
+	provision[1].After = []string{"dotfiles"}
+	_, err = ProvisionOrder(provision)
+	assert.ErrorContains(t, err, "dotfiles, vim")
+}
+
+func TestValidateProvision(t *testing.T) {
+	assert.NilError(t, validateProvision([]Provision{
+		{Mode: ProvisionModeDependency, Name: "deps"},
+		{Mode: ProvisionModeSystem, Name: "foo", After: []string{"deps"}, RunOnce: true},
+	}))
+	assert.ErrorContains(t, validateProvision([]Provision{{Mode: "root", Name: "foo"}}), "provision[0].mode")
+	assert.ErrorContains(t, validateProvision([]Provision{{Mode: ProvisionModeSystem, Name: "foo/bar"}}), "provision[0].name")
+	assert.ErrorContains(t, validateProvision([]Provision{
+		{Mode: ProvisionModeSystem, Name: "foo"},
+		{Mode: ProvisionModeUser, Name: "foo"},
+	}), "duplicates")
+	assert.ErrorContains(t, validateProvision([]Provision{{Mode: ProvisionModeSystem, Name: "foo", After: []string{"bar"}}}), "unknown script \"bar\"")
+	assert.ErrorContains(t, validateProvision([]Provision{
+		{Mode: ProvisionModeSystem, Name: "foo"},
+		{Mode: ProvisionModeDependency, Name: "deps", After: []string{"foo"}},
+	}), "provision[1].after")
+}
@@ -2,17 +2,33 @@ package limayaml
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 
+	"github.com/AkihiroSuda/lima/pkg/downloader"
+	"github.com/AkihiroSuda/lima/pkg/envutil"
 	"github.com/AkihiroSuda/lima/pkg/localpathutil"
+	"github.com/containerd/containerd/identifiers"
 	"github.com/docker/go-units"
 	"github.com/pkg/errors"
 )
 
+// Validate fills the default values and validates the yaml.
+// y is not modified.
 func Validate(y LimaYAML) error {
+	// Copy the slices, as FillDefault modifies the elements in place
+	y.Images = append([]Image(nil), y.Images...)
+	y.Mounts = append([]Mount(nil), y.Mounts...)
+	y.Provision = append([]Provision(nil), y.Provision...)
+	y.Probes = append([]Probe(nil), y.Probes...)
+	y.PortForwards = append([]PortForward(nil), y.PortForwards...)
 	FillDefault(&y)
 	return ValidateRaw(y)
 }
@@ -40,6 +56,11 @@ func ValidateRaw(y LimaYAML) error {
 		default:
 			return errors.Errorf("field `images.arch` must be %q or %q, got %q", X8664, AARCH64, f.Arch)
 		}
+		if f.Digest != "" {
+			if err := downloader.ValidateDigest(f.Digest); err != nil {
+				return errors.Wrapf(err, "field `images[%d].digest` is invalid", i)
+			}
+		}
 	}
 
 	if y.CPUs == 0 {
@@ -54,6 +75,17 @@ func ValidateRaw(y LimaYAML) error {
 		return errors.Wrapf(err, "field `memory` has an invalid value")
 	}
 
+	disks := make(map[string]bool)
+	for i, f := range y.AdditionalDisks {
+		if err := identifiers.Validate(f); err != nil {
+			return errors.Wrapf(err, "field `additionalDisks[%d]` is invalid", i)
+		}
+		if disks[f] {
+			return errors.Errorf("field `additionalDisks[%d]` is duplicated: %q", i, f)
+		}
+		disks[f] = true
+	}
+
 	u, err := user.Current()
 	if err != nil {
 		return errors.Wrap(err, "internal error (not an error of YAML)")
@@ -61,7 +93,14 @@ func ValidateRaw(y LimaYAML) error {
 	// reservedHome is the home directory defined in "cidata.iso:/user-data"
 	reservedHome := fmt.Sprintf("/home/%s.linux", u.Username)
 
+	if err := validateMountType(y.MountType); err != nil {
+		return errors.Wrap(err, "field `mountType` is invalid")
+	}
+	mountPoints := make(map[string]bool)
 	for i, f := range y.Mounts {
+		if err := validateMountType(f.MountType); err != nil {
+			return errors.Wrapf(err, "field `mounts[%d].mountType` is invalid", i)
+		}
 		if !filepath.IsAbs(f.Location) && !strings.HasPrefix(f.Location, "~") {
 			return errors.Errorf("field `mounts[%d].location` must be an absolute path, got %q",
 				i, f.Location)
@@ -88,13 +127,56 @@ func ValidateRaw(y LimaYAML) error {
 			return errors.Wrapf(err, "field `mounts[%d].location` refers to a non-directory path: %q",
 				i, f.Location)
 		}
+
+		if err := validateMountPoint(f.MountPoint, reservedHome); err != nil {
+			return errors.Wrapf(err, "field `mounts[%d].mountPoint` is invalid", i)
+		}
+		if mountPoints[f.MountPoint] {
+			return errors.Errorf("field `mounts[%d].mountPoint` is duplicated: %q", i, f.MountPoint)
+		}
+		mountPoints[f.MountPoint] = true
+
+		if f.MountType != ReverseSSHFS {
+			if f.UID != nil || f.GID != nil || f.Umask != "" {
+				return errors.Errorf("fields `mounts[%d].uid`, `mounts[%d].gid`, and `mounts[%d].umask` are only supported for mountType %q",
+					i, i, i, ReverseSSHFS)
+			}
+		}
+		if f.UID != nil && *f.UID < 0 {
+			return errors.Errorf("field `mounts[%d].uid` must be >= 0", i)
+		}
+		if f.GID != nil && *f.GID < 0 {
+			return errors.Errorf("field `mounts[%d].gid` must be >= 0", i)
+		}
+		if f.Umask != "" {
+			if umask, err := strconv.ParseUint(f.Umask, 8, 32); err != nil || umask > 0777 {
+				return errors.Errorf("field `mounts[%d].umask` must be an octal number such as \"022\", got %q", i, f.Umask)
+			}
+		}
+	}
+
+	if err := validateNetworks(y.Networks); err != nil {
+		return err
+	}
+	if err := validateDNS(y.DNS, *y.HostResolver.Enabled); err != nil {
+		return err
+	}
+	if err := validateProxy(y.Proxy); err != nil {
+		return err
+	}
+	for k, v := range y.Env {
+		if err := envutil.ValidateName(k); err != nil {
+			return errors.Wrap(err, "field `env` is invalid")
+		}
+		// The value is embedded in /etc/environment and shell scripts in the guest
+		if strings.ContainsAny(v, "\r\n\"`$\\") {
+			return errors.Errorf("field `env.%s` must not contain newlines, double quotes, '`', '$', or '\\', got %q", k, v)
+		}
 	}
 
 	switch {
 	case y.SSH.LocalPort < 0:
-		return errors.New("field `ssh.localPort` must be > 0")
-	case y.SSH.LocalPort == 0:
-		return errors.New("field `ssh.localPort` must be set, e.g, 60022 (FIXME: support automatic port assignment)")
+		return errors.New("field `ssh.localPort` must be >= 0")
 	case y.SSH.LocalPort == 22:
 		return errors.New("field `ssh.localPort` must not be 22")
 	case y.SSH.LocalPort > 65535:
@@ -103,13 +185,8 @@ func ValidateRaw(y LimaYAML) error {
 
 	// y.Firmware.LegacyBIOS is ignored for aarch64, but not a fatal error.
 
-	for i, p := range y.Provision {
-		switch p.Mode {
-		case ProvisionModeSystem, ProvisionModeUser:
-		default:
-			return errors.Errorf("field `provision[%d].mode` must be either %q or %q",
-					i, ProvisionModeSystem, ProvisionModeUser)
-		}
+	if err := validateProvision(y.Provision); err != nil {
+		return err
 	}
 	for i, p := range y.Probes {
 		switch p.Mode {
@@ -119,5 +196,257 @@ func ValidateRaw(y LimaYAML) error {
 				i, ProbeModeReadiness)
 		}
 	}
+	for i, rule := range y.PortForwards {
+		field := fmt.Sprintf("portForwards[%d]", i)
+		if rule.GuestPort != 0 {
+			if err := validatePort(field+".guestPort", rule.GuestPort); err != nil {
+				return err
+			}
+			if rule.GuestPortRange[0] != rule.GuestPort || rule.GuestPortRange[1] != rule.GuestPort {
+				return errors.Errorf("field `%s.guestPort` conflicts with `%s.guestPortRange`", field, field)
+			}
+		}
+		if rule.HostPort != 0 {
+			if err := validatePort(field+".hostPort", rule.HostPort); err != nil {
+				return err
+			}
+			if rule.HostPortRange[0] != rule.HostPort || rule.HostPortRange[1] != rule.HostPort {
+				return errors.Errorf("field `%s.hostPort` conflicts with `%s.hostPortRange`", field, field)
+			}
+		}
+		for j := 0; j < 2; j++ {
+			if err := validatePort(fmt.Sprintf("%s.guestPortRange[%d]", field, j), rule.GuestPortRange[j]); err != nil {
+				return err
+			}
+			if err := validatePort(fmt.Sprintf("%s.hostPortRange[%d]", field, j), rule.HostPortRange[j]); err != nil {
+				return err
+			}
+		}
+		if rule.GuestPortRange[0] > rule.GuestPortRange[1] {
+			return errors.Errorf("field `%s.guestPortRange[1]` must be greater than or equal to field `%s.guestPortRange[0]`", field, field)
+		}
+		if rule.HostPortRange[0] > rule.HostPortRange[1] {
+			return errors.Errorf("field `%s.hostPortRange[1]` must be greater than or equal to field `%s.hostPortRange[0]`", field, field)
+		}
+		if rule.GuestPortRange[1]-rule.GuestPortRange[0] != rule.HostPortRange[1]-rule.HostPortRange[0] {
+			return errors.Errorf("field `%s.hostPortRange` must specify the same number of ports as field `%s.guestPortRange`", field, field)
+		}
+		if rule.GuestIP == nil {
+			return errors.Errorf("field `%s.guestIP` must be set", field)
+		}
+		if rule.HostIP == nil {
+			return errors.Errorf("field `%s.hostIP` must be set", field)
+		}
+		switch rule.Proto {
+		case TCP, UDP:
+		default:
+			return errors.Errorf("field `%s.proto` must be %q or %q", field, TCP, UDP)
+		}
+		// Not validating that the various GuestPortRanges and HostPortRanges are not overlapping. Rules will be
+		// processed sequentially and the first matching rule for a guest port determines forwarding behavior.
+	}
 	return nil
 }
+
+func validatePort(field string, port int) error {
+	switch {
+	case port < 0:
+		return errors.Errorf("field `%s` must be > 0", field)
+	case port == 0:
+		return errors.Errorf("field `%s` must be set", field)
+	case port > 65535:
+		return errors.Errorf("field `%s` must be < 65536", field)
+	}
+	return nil
+}
+
+func validateDNS(dns DNS, hostResolver bool) error {
+	if hostResolver && len(dns.Servers) > 0 {
+		return errors.New("field `dns.servers` conflicts with `hostResolver.enabled`")
+	}
+	for i, ip := range dns.Servers {
+		if ip == nil {
+			return errors.Errorf("field `dns.servers[%d]` must be an IP address", i)
+		}
+	}
+	for i, domain := range dns.Search {
+		if err := validateHostname(domain); err != nil {
+			return errors.Wrapf(err, "field `dns.search[%d]` is invalid", i)
+		}
+	}
+	for name, ip := range dns.Hosts {
+		if err := validateHostname(name); err != nil {
+			return errors.Wrapf(err, "field `dns.hosts` has an invalid name")
+		}
+		if ip == nil {
+			return errors.Errorf("field `dns.hosts.%s` must be an IP address", name)
+		}
+	}
+	return nil
+}
+
+func validateProxy(proxy Proxy) error {
+	for field, s := range map[string]string{"http": proxy.HTTP, "https": proxy.HTTPS} {
+		if s == "" {
+			continue
+		}
+		if err := ValidateProxyURL(s); err != nil {
+			return errors.Wrapf(err, "field `proxy.%s` is invalid", field)
+		}
+	}
+	for i, f := range proxy.NoProxy {
+		if err := ValidateNoProxy(f); err != nil {
+			return errors.Wrapf(err, "field `proxy.noProxy[%d]` is invalid", i)
+		}
+	}
+	return nil
+}
+
+// ValidateProxyURL validates the value of `proxy.http` and `proxy.https`.
+func ValidateProxyURL(s string) error {
+	// The value is embedded in shell scripts and config files in the guest
+	if strings.ContainsAny(s, " \t\r\n\"'`$\\") {
+		return errors.Errorf("must not contain whitespaces, quotes, '$', or '\\', got %q", s)
+	}
+	_, err := ParseProxyURL(s)
+	return err
+}
+
+// ValidateNoProxy validates an entry of `proxy.noProxy`.
+func ValidateNoProxy(s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"'`$\\,") {
+		return errors.Errorf("invalid entry %q", s)
+	}
+	return nil
+}
+
+// ParseProxyURL parses the proxy URL s.
+// The scheme defaults to "http", e.g., "127.0.0.1:3128" is parsed as "http://127.0.0.1:3128".
+func ParseProxyURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf("proxy %q has no host", s)
+	}
+	return u, nil
+}
+
+func validateHostname(name string) error {
+	if name == "" || len(name) > 253 {
+		return errors.Errorf("invalid hostname %q", name)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return errors.Errorf("invalid hostname %q", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return errors.Errorf("invalid hostname %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateNetworks(networks []Network) error {
+	var users int
+	limaNetworks := make(map[string]bool)
+	for i, nw := range networks {
+		field := fmt.Sprintf("networks[%d]", i)
+		switch nw.Type {
+		case NetworkUser:
+			users++
+		case NetworkTap, NetworkBridge:
+			// tap and bridge require the privileges to be configured on the host, which is only implemented for Linux
+			if runtime.GOOS != "linux" {
+				return errors.Errorf("field `%s.type` %q is only supported on Linux hosts", field, nw.Type)
+			}
+			if nw.Interface == "" {
+				return errors.Errorf("field `%s.interface` must be set for type %q", field, nw.Type)
+			}
+		case NetworkVDE:
+			if nw.Switch == "" {
+				return errors.Errorf("field `%s.switch` must be set for type %q", field, nw.Type)
+			}
+			if _, err := localpathutil.Expand(nw.Switch); err != nil {
+				return errors.Wrapf(err, "field `%s.switch` refers to an unexpandable path: %q", field, nw.Switch)
+			}
+		case NetworkLima:
+			if err := identifiers.Validate(nw.Network); err != nil {
+				return errors.Wrapf(err, "field `%s.network` is invalid", field)
+			}
+			if limaNetworks[nw.Network] {
+				return errors.Errorf("field `%s.network` is duplicated: %q", field, nw.Network)
+			}
+			limaNetworks[nw.Network] = true
+		default:
+			return errors.Errorf("field `%s.type` must be %q, %q, %q, %q, or %q, got %q",
+				field, NetworkUser, NetworkTap, NetworkBridge, NetworkVDE, NetworkLima, nw.Type)
+		}
+		if nw.Type != NetworkTap && nw.Type != NetworkBridge && nw.Interface != "" {
+			return errors.Errorf("field `%s.interface` is only supported for type %q and %q", field, NetworkTap, NetworkBridge)
+		}
+		if nw.Type != NetworkVDE && nw.Switch != "" {
+			return errors.Errorf("field `%s.switch` is only supported for type %q", field, NetworkVDE)
+		}
+		if nw.Type != NetworkLima && nw.Network != "" {
+			return errors.Errorf("field `%s.network` is only supported for type %q", field, NetworkLima)
+		}
+		if nw.MACAddress != "" {
+			if _, err := net.ParseMAC(nw.MACAddress); err != nil {
+				return errors.Wrapf(err, "field `%s.macAddress` is invalid", field)
+			}
+		}
+	}
+	// The user network is needed for SSH, which is used for the guest agent, the mounts, and the port forwarding
+	if users != 1 {
+		return errors.Errorf("field `networks` must contain exactly one network of type %q, got %d", NetworkUser, users)
+	}
+	return nil
+}
+
+func validateMountType(t MountType) error {
+	switch t {
+	case ReverseSSHFS:
+	case NineP:
+		// QEMU `-virtfs` (virtio-9p) is not available on macOS hosts
+		if runtime.GOOS == "darwin" {
+			return errors.Errorf("mount type %q is not supported on macOS hosts", t)
+		}
+	case VirtIOFS:
+		// virtiofs requires vhost-user, which is not implemented for non-Linux hosts
+		if runtime.GOOS != "linux" {
+			return errors.Errorf("mount type %q is only supported on Linux hosts", t)
+		}
+	default:
+		return errors.Errorf("expected %q, %q, or %q, got %q", ReverseSSHFS, NineP, VirtIOFS, t)
+	}
+	return nil
+}
+
+// validateMountPoint validates the mount point in the guest.
+func validateMountPoint(p, reservedHome string) error {
+	if !path.IsAbs(p) {
+		return errors.Errorf("must be an absolute path, got %q", p)
+	}
+	p = path.Clean(p)
+	switch p {
+	case "/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib64", "/opt", "/proc", "/root", "/run", "/sbin", "/sys", "/tmp", "/usr", "/var":
+		return errors.Errorf("must not be a system path such as /etc or /usr, got %q", p)
+	}
+	const cidataMountPoint = "/mnt/lima-cidata"
+	if isPathUnder(p, reservedHome) || isPathUnder(p, cidataMountPoint) || isPathUnder(cidataMountPoint, p) {
+		return errors.Errorf("%q overlaps with the internally reserved path %q or %q", p, reservedHome, cidataMountPoint)
+	}
+	return nil
+}
+
+// isPathUnder returns true if the clean path p is base or under base, on the path-segment boundaries.
+func isPathUnder(p, base string) bool {
+	return p == base || strings.HasPrefix(p, strings.TrimSuffix(base, "/")+"/")
+}
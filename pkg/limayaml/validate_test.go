@@ -0,0 +1,67 @@
+package limayaml
+
+import (
+	"net"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateMountPoint(t *testing.T) {
+	const reservedHome = "/home/foo.linux"
+	assert.NilError(t, validateMountPoint("/workspace", reservedHome))
+	assert.NilError(t, validateMountPoint("/Users/foo", reservedHome))
+	assert.NilError(t, validateMountPoint("/home/bar", reservedHome))
+	assert.NilError(t, validateMountPoint("/tmp/lima", reservedHome))
+	assert.NilError(t, validateMountPoint("/mnt/data", reservedHome))
+	assert.NilError(t, validateMountPoint("/etcetera", reservedHome))
+	assert.NilError(t, validateMountPoint("/var/folders/xx/T", reservedHome))
+	assert.NilError(t, validateMountPoint("/var/www", reservedHome))
+	assert.NilError(t, validateMountPoint("/usr/local/src/foo", reservedHome))
+	assert.ErrorContains(t, validateMountPoint("workspace", reservedHome), "absolute")
+	assert.ErrorContains(t, validateMountPoint("/etc/", reservedHome), "system path")
+	assert.ErrorContains(t, validateMountPoint("/usr", reservedHome), "system path")
+	assert.ErrorContains(t, validateMountPoint("/proc", reservedHome), "system path")
+	assert.ErrorContains(t, validateMountPoint("/home", reservedHome), "system path")
+	assert.ErrorContains(t, validateMountPoint("/", reservedHome), "system path")
+	assert.ErrorContains(t, validateMountPoint(reservedHome, reservedHome), "reserved")
+	assert.ErrorContains(t, validateMountPoint("/home/foo.linux/work", reservedHome), "reserved")
+	assert.ErrorContains(t, validateMountPoint("/home/foo.linux/.ssh", reservedHome), "reserved")
+	assert.ErrorContains(t, validateMountPoint("/mnt", reservedHome), "reserved")
+	assert.ErrorContains(t, validateMountPoint("/mnt/lima-cidata/foo", reservedHome), "reserved")
+}
+
+func TestValidateNetworks(t *testing.T) {
+	user := Network{Type: NetworkUser}
+	vde := Network{Type: NetworkVDE, Switch: "/tmp/vde.ctl"}
+	assert.NilError(t, validateNetworks([]Network{user}))
+	assert.NilError(t, validateNetworks([]Network{user, vde, {Type: NetworkVDE, Switch: "~/vde.ctl", MACAddress: "52:55:55:12:34:56"}}))
+	assert.ErrorContains(t, validateNetworks(nil), "exactly one")
+	assert.ErrorContains(t, validateNetworks([]Network{vde}), "exactly one")
+	assert.ErrorContains(t, validateNetworks([]Network{user, user}), "exactly one")
+	assert.ErrorContains(t, validateNetworks([]Network{user, {Type: NetworkVDE}}), "networks[1].switch")
+	assert.ErrorContains(t, validateNetworks([]Network{user, {Type: "slirp"}}), "networks[1].type")
+	assert.ErrorContains(t, validateNetworks([]Network{{Type: NetworkUser, MACAddress: "foo"}}), "macAddress")
+}
+
+func TestValidateDNS(t *testing.T) {
+	assert.NilError(t, validateDNS(DNS{}, true))
+	assert.NilError(t, validateDNS(DNS{
+		Servers: []net.IP{net.ParseIP("1.1.1.1")},
+		Search:  []string{"corp.example.com"},
+		Hosts:   map[string]net.IP{"foo.corp.example.com": net.ParseIP("10.0.0.1")},
+	}, false))
+	assert.ErrorContains(t, validateDNS(DNS{Servers: []net.IP{net.ParseIP("1.1.1.1")}}, true), "conflicts")
+	assert.ErrorContains(t, validateDNS(DNS{Servers: []net.IP{nil}}, false), "dns.servers[0]")
+	assert.ErrorContains(t, validateDNS(DNS{Search: []string{"foo bar"}}, false), "dns.search[0]")
+	assert.ErrorContains(t, validateDNS(DNS{Hosts: map[string]net.IP{"-foo": net.ParseIP("10.0.0.1")}}, false), "invalid hostname")
+	assert.ErrorContains(t, validateDNS(DNS{Hosts: map[string]net.IP{"foo": nil}}, false), "dns.hosts.foo")
+}
+
+func TestValidateProxy(t *testing.T) {
+	assert.NilError(t, validateProxy(Proxy{}))
+	assert.NilError(t, validateProxy(Proxy{HTTP: "http://127.0.0.1:3128", HTTPS: "proxy.example.com:3128", NoProxy: []string{".example.com"}}))
+	assert.ErrorContains(t, validateProxy(Proxy{HTTP: "http://"}), "proxy.http")
+	assert.ErrorContains(t, validateProxy(Proxy{HTTPS: "http://proxy.example.com:3128 $(reboot)"}), "proxy.https")
+	assert.ErrorContains(t, validateProxy(Proxy{NoProxy: []string{"a,b"}}), "proxy.noProxy[0]")
+}
@@ -0,0 +1,66 @@
+package qemu
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strconv"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+// DiskVirtualSize returns the virtual size of the disk image, in bytes.
+func DiskVirtualSize(disk string) (int64, error) {
+	// -U (--force-share) is needed for reading the disk of a running instance
+	cmd := exec.Command("qemu-img", "info", "--output=json", "-U", disk)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to run %v: %q", cmd.Args, stderrOf(err))
+	}
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return 0, errors.Wrapf(err, "failed to parse the output of %v", cmd.Args)
+	}
+	return info.VirtualSize, nil
+}
+
+// ResizeDisk grows the disk image to size bytes.
+// Shrinking is refused, as it would destroy the filesystem in the guest.
+// The disk must not be in use.
+//
+// ResizeDisk returns false when the disk already has the size.
+func ResizeDisk(disk string, size int64) (bool, error) {
+	current, err := DiskVirtualSize(disk)
+	if err != nil {
+		return false, err
+	}
+	// qemu-img rounds up the size to the sector size
+	size = roundUp(size, 512)
+	switch {
+	case size == current:
+		return false, nil
+	case size < current:
+		return false, errors.Errorf("shrinking the disk %q from %s to %s is not supported",
+			disk, units.BytesSize(float64(current)), units.BytesSize(float64(size)))
+	}
+	cmd := exec.Command("qemu-img", "resize", disk, strconv.FormatInt(size, 10))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return false, errors.Wrapf(err, "failed to run %v: %q", cmd.Args, string(out))
+	}
+	return true, nil
+}
+
+func roundUp(n, unit int64) int64 {
+	return (n + unit - 1) / unit * unit
+}
+
+// CreateDisk creates a new empty qcow2 disk image of size bytes.
+func CreateDisk(disk string, size int64) error {
+	cmd := exec.Command("qemu-img", "create", "-f", "qcow2", disk, strconv.FormatInt(size, 10))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to run %v: %q", cmd.Args, string(out))
+	}
+	return nil
+}
@@ -1,16 +1,20 @@
 package qemu
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/AkihiroSuda/lima/pkg/downloader"
 	"github.com/AkihiroSuda/lima/pkg/iso9660util"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/localpathutil"
 	"github.com/AkihiroSuda/lima/pkg/store/filenames"
 	"github.com/docker/go-units"
 	"github.com/pkg/errors"
@@ -21,12 +25,24 @@ type Config struct {
 	Name        string
 	InstanceDir string
 	LimaYAML    *limayaml.LimaYAML
+	// AdditionalDisks are the paths of the disks specified in the `additionalDisks` field
+	AdditionalDisks []string
+	// MulticastAddresses are the multicast addresses of the networks of type "lima", keyed by the network names
+	MulticastAddresses map[string]string
 }
 
-func EnsureDisk(cfg Config) error {
+// SlirpGateway is the address of the host in the user network (`-netdev user,net=192.168.5.0/24`).
+// The connections to SlirpGateway are forwarded to 127.0.0.1 of the host.
+const SlirpGateway = "192.168.5.2"
+
+// EnsureDisk downloads the base disk and creates the diff disk, if they do not exist yet.
+// An existing diff disk is grown when the `disk` field was increased.
+// downloadOpts are passed to downloader.Download, in addition to the default options.
+func EnsureDisk(cfg Config, downloadOpts ...downloader.Opt) error {
 	diffDisk := filepath.Join(cfg.InstanceDir, filenames.DiffDisk)
-	if _, err := os.Stat(diffDisk); err == nil || !errors.Is(err, os.ErrNotExist) {
-		// disk is already ensured
+	if _, err := os.Stat(diffDisk); err == nil {
+		return growDisk(cfg, diffDisk)
+	} else if !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
 
@@ -40,7 +56,8 @@ func EnsureDisk(cfg Config) error {
 				continue
 			}
 			logrus.Infof("Attempting to download the image from %q", f.Location)
-			res, err := downloader.Download(baseDisk, f.Location, downloader.WithCache())
+			opts := append([]downloader.Opt{downloader.WithCache(), downloader.WithExpectedDigest(f.Digest)}, downloadOpts...)
+			res, err := downloader.Download(baseDisk, f.Location, opts...)
 			if err != nil {
 				errs[i] = errors.Wrapf(err, "failed to download %q", f.Location)
 				continue
@@ -81,6 +98,43 @@ func EnsureDisk(cfg Config) error {
 	return nil
 }
 
+// growDisk grows the existing diff disk to the size specified in the `disk` field.
+func growDisk(cfg Config, diffDisk string) error {
+	diskSize, _ := units.RAMInBytes(cfg.LimaYAML.Disk)
+	if diskSize == 0 {
+		return nil
+	}
+	resized, err := ResizeDisk(diffDisk, diskSize)
+	if err != nil {
+		return errors.Wrap(err, "failed to resize the disk to the size specified in the field `disk`")
+	}
+	if resized {
+		logrus.Infof("Resized the disk to %s", cfg.LimaYAML.Disk)
+	}
+	return nil
+}
+
+// DiskSerial returns the serial of the i-th additional disk, as seen in /sys/block/*/serial in the guest.
+// The serial of virtio-blk is limited to 20 bytes, so the name of the disk is not used.
+func DiskSerial(i int) string {
+	return fmt.Sprintf("lima-disk%d", i)
+}
+
+// MACAddress returns the MAC address of `networks[i]`.
+func MACAddress(instName string, i int, nw limayaml.Network) string {
+	if nw.MACAddress != "" {
+		return nw.MACAddress
+	}
+	if nw.Type == limayaml.NetworkUser {
+		// The default of QEMU, kept for the guests that have been configured with it
+		return "52:54:00:12:34:56"
+	}
+	// The MAC address has to be unique across the instances on the same network
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", instName, i)))
+	// "52:55:55" is locally administered, and distinct from the default of QEMU ("52:54:00")
+	return fmt.Sprintf("52:55:55:%02x:%02x:%02x", h[0], h[1], h[2])
+}
+
 func Cmdline(cfg Config) (string, []string, error) {
 	y := cfg.LimaYAML
 	exeBase := "qemu-system-" + y.Arch
@@ -113,6 +167,11 @@ func Cmdline(cfg Config) (string, []string, error) {
 		return "", nil, err
 	}
 	args = append(args, "-m", strconv.Itoa(int(memBytes>>20)))
+	if hasMountType(y, limayaml.VirtIOFS) {
+		// vhost-user-fs requires the guest memory to be shared with virtiofsd
+		args = append(args, "-object", fmt.Sprintf("memory-backend-memfd,id=mem,size=%dM,share=on", memBytes>>20))
+		args = append(args, "-numa", "node,memdev=mem")
+	}
 
 	// Firmware
 	if !y.Firmware.LegacyBIOS {
@@ -142,14 +201,48 @@ func Cmdline(cfg Config) (string, []string, error) {
 	} else if !isBaseDiskCDROM {
 		args = append(args, "-drive", fmt.Sprintf("file=%s,if=virtio", baseDisk))
 	}
+	for i, f := range cfg.AdditionalDisks {
+		id := DiskSerial(i)
+		args = append(args, "-drive", fmt.Sprintf("file=%s,if=none,id=%s,format=qcow2", f, id))
+		args = append(args, "-device", fmt.Sprintf("virtio-blk-pci,drive=%s,serial=%s", id, id))
+	}
 	// cloud-init
 	args = append(args, "-cdrom", filepath.Join(cfg.InstanceDir, filenames.CIDataISO))
 
 	// Network
-	// CIDR is intentionally hardcoded to 192.168.5.0/24, as each of QEMU has its own independent slirp network.
-	// TODO: enable bridge (with sudo?)
-	args = append(args, "-net", "nic,model=virtio")
-	args = append(args, "-net", fmt.Sprintf("user,net=192.168.5.0/24,hostfwd=tcp:127.0.0.1:%d-:22", y.SSH.LocalPort))
+	for i, nw := range y.Networks {
+		id := fmt.Sprintf("net%d", i)
+		var netdev string
+		switch nw.Type {
+		case limayaml.NetworkUser:
+			// CIDR is intentionally hardcoded to 192.168.5.0/24, as each of QEMU has its own independent slirp network.
+			netdev = fmt.Sprintf("user,id=%s,net=192.168.5.0/24,hostfwd=tcp:127.0.0.1:%d-:22", id, y.SSH.LocalPort)
+		case limayaml.NetworkTap:
+			// The tap device has to be created in advance, e.g., `sudo ip tuntap add dev tap0 mode tap user $USER`
+			netdev = fmt.Sprintf("tap,id=%s,ifname=%s,script=no,downscript=no", id, escapeOpt(nw.Interface))
+		case limayaml.NetworkBridge:
+			// The bridge has to be allowed in /etc/qemu/bridge.conf, for qemu-bridge-helper
+			netdev = fmt.Sprintf("bridge,id=%s,br=%s", id, escapeOpt(nw.Interface))
+		case limayaml.NetworkVDE:
+			sock, err := localpathutil.Expand(nw.Switch)
+			if err != nil {
+				return "", nil, err
+			}
+			netdev = fmt.Sprintf("vde,id=%s,sock=%s", id, escapeOpt(sock))
+		case limayaml.NetworkLima:
+			mcast, ok := cfg.MulticastAddresses[nw.Network]
+			if !ok {
+				return "", nil, errors.Errorf("unknown network %q", nw.Network)
+			}
+			// localaddr=127.0.0.1 keeps the multicast packets inside the host.
+			// The loopback interface needs the MULTICAST flag, see CheckLoopbackMulticast.
+			netdev = fmt.Sprintf("socket,id=%s,mcast=%s,localaddr=127.0.0.1", id, mcast)
+		default:
+			return "", nil, errors.Errorf("unexpected network type %q", nw.Type)
+		}
+		args = append(args, "-netdev", netdev)
+		args = append(args, "-device", fmt.Sprintf("virtio-net-pci,netdev=%s,mac=%s", id, MACAddress(cfg.Name, i, nw)))
+	}
 
 	// virtio-rng-pci acceralates starting up the OS, according to https://wiki.gentoo.org/wiki/QEMU/Options
 	args = append(args, "-device", "virtio-rng-pci")
@@ -187,7 +280,28 @@ func Cmdline(cfg Config) (string, []string, error) {
 	args = append(args, "-chardev", fmt.Sprintf("socket,id=%s,path=%s,server,nowait,logfile=%s", serialChardev, serialSock, serialLog))
 	args = append(args, "-serial", "chardev:"+serialChardev)
 
-	// We also want to enable vsock and virtfs here, but QEMU does not support vsock and virtfs for macOS hosts
+	// Mounts (reverse-sshfs mounts are set up by the host agent)
+	for i, m := range y.Mounts {
+		location, err := localpathutil.Expand(m.Location)
+		if err != nil {
+			return "", nil, err
+		}
+		tag := MountTag(i)
+		switch m.MountType {
+		case limayaml.NineP:
+			opts := fmt.Sprintf("local,path=%s,mount_tag=%s,security_model=none", escapeOpt(location), tag)
+			if !m.Writable {
+				opts += ",readonly=on"
+			}
+			args = append(args, "-virtfs", opts)
+		case limayaml.VirtIOFS:
+			chardev := fmt.Sprintf("char-virtiofs-%d", i)
+			args = append(args, "-chardev", fmt.Sprintf("socket,id=%s,path=%s", chardev, virtiofsdSock(cfg.InstanceDir, i)))
+			args = append(args, "-device", fmt.Sprintf("vhost-user-fs-pci,queue-size=1024,chardev=%s,tag=%s", chardev, tag))
+		}
+	}
+
+	// We also want to enable vsock here, but QEMU does not support vsock for macOS hosts
 
 	// QMP
 	qmpSock := filepath.Join(cfg.InstanceDir, filenames.QMPSock)
@@ -205,6 +319,150 @@ func Cmdline(cfg Config) (string, []string, error) {
 	return exe, args, nil
 }
 
+// MountTag returns the 9p/virtiofs tag of mounts[i].
+func MountTag(i int) string {
+	return fmt.Sprintf("lima-mount%d", i)
+}
+
+func hasMountType(y *limayaml.LimaYAML, t limayaml.MountType) bool {
+	for _, m := range y.Mounts {
+		if m.MountType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckLoopbackMulticast checks that the loopback interface can be used for the multicast
+// of the networks of type "lima" (`-netdev socket,mcast=...,localaddr=127.0.0.1`).
+// The loopback interface of Linux does not have the MULTICAST flag by default, unlike lo0 of macOS.
+func CheckLoopbackMulticast() error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		if iface.Flags&net.FlagMulticast == 0 {
+			hint := "enable the MULTICAST flag of the loopback interface"
+			if runtime.GOOS == "linux" {
+				hint = fmt.Sprintf("run `sudo ip link set %s multicast on`", iface.Name)
+			}
+			return errors.Errorf("the loopback interface %q does not support multicast, which is required for the networks of type %q (hint: %s)",
+				iface.Name, limayaml.NetworkLima, hint)
+		}
+		return nil
+	}
+	return errors.Errorf("no loopback interface was found, which is required for the networks of type %q", limayaml.NetworkLima)
+}
+
+// escapeOpt escapes commas in the value of a QEMU option.
+func escapeOpt(s string) string {
+	return strings.ReplaceAll(s, ",", ",,")
+}
+
+func virtiofsdSock(instDir string, i int) string {
+	return filepath.Join(instDir, fmt.Sprintf(filenames.VirtiofsdSock, i))
+}
+
+// Virtiofsd is a virtiofsd process to be started for a virtiofs mount.
+type Virtiofsd struct {
+	Cmdline []string
+	Socket  string // created by virtiofsd
+}
+
+// VirtiofsdCmdlines returns the virtiofsd processes for the mounts with `mountType: virtiofs`.
+// virtiofsd has to be started before QEMU, and exits when QEMU exits.
+func VirtiofsdCmdlines(cfg Config) ([]Virtiofsd, error) {
+	y := cfg.LimaYAML
+	if !hasMountType(y, limayaml.VirtIOFS) {
+		return nil, nil
+	}
+	exe, err := findVirtiofsd()
+	if err != nil {
+		return nil, err
+	}
+	var res []Virtiofsd
+	for i, m := range y.Mounts {
+		if m.MountType != limayaml.VirtIOFS {
+			continue
+		}
+		location, err := localpathutil.Expand(m.Location)
+		if err != nil {
+			return nil, err
+		}
+		sock := virtiofsdSock(cfg.InstanceDir, i)
+		if err := os.RemoveAll(sock); err != nil {
+			return nil, err
+		}
+		res = append(res, Virtiofsd{Cmdline: virtiofsdCmdline(exe, sock, location, m.Writable), Socket: sock})
+	}
+	return res, nil
+}
+
+// virtiofsdCmdline returns the command line of the Rust implementation of virtiofsd.
+func virtiofsdCmdline(exe, sock, location string, writable bool) []string {
+	// "--sandbox=none" allows running virtiofsd without the root privilege
+	cmdline := []string{exe, "--socket-path=" + sock, "--shared-dir=" + location, "--sandbox=none", "--cache=auto"}
+	if !writable {
+		cmdline = append(cmdline, "--readonly")
+	}
+	return cmdline
+}
+
+// isLegacyVirtiofsd returns true if exe is the C implementation of virtiofsd.
+func isLegacyVirtiofsd(exe string) (bool, error) {
+	cmd := exec.Command(exe, "--version")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to run %v: %q", cmd.Args, string(out))
+	}
+	return isLegacyVirtiofsdVersion(string(out)), nil
+}
+
+// isLegacyVirtiofsdVersion parses the output of `virtiofsd --version`.
+// The Rust implementation prints "virtiofsd 1.10.1",
+// the C implementation prints "virtiofsd version 6.2.0 (...)" and the FUSE version.
+func isLegacyVirtiofsdVersion(s string) bool {
+	firstLine := strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+	return !strings.HasPrefix(firstLine, "virtiofsd ") || strings.HasPrefix(firstLine, "virtiofsd version ")
+}
+
+// findVirtiofsd finds the Rust implementation of virtiofsd (https://gitlab.com/virtio-fs/virtiofsd).
+// The C implementation (bundled with QEMU < 8.0) is rejected, as it requires the root privilege
+// for its sandbox, and cannot enforce read-only mounts on the host side.
+func findVirtiofsd() (string, error) {
+	var candidates []string
+	if exe, err := exec.LookPath("virtiofsd"); err == nil {
+		candidates = append(candidates, exe)
+	}
+	candidates = append(candidates,
+		"/usr/libexec/virtiofsd",  // Fedora, Debian
+		"/usr/lib/qemu/virtiofsd", // Debian (QEMU < 8.0, the C implementation)
+	)
+	var legacy []string
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		isLegacy, err := isLegacyVirtiofsd(f)
+		if err != nil {
+			return "", err
+		}
+		if isLegacy {
+			legacy = append(legacy, f)
+			continue
+		}
+		return f, nil
+	}
+	if len(legacy) > 0 {
+		return "", errors.Errorf("`mountType: virtiofs` requires the Rust implementation of virtiofsd (https://gitlab.com/virtio-fs/virtiofsd), found only the legacy C implementation %v", legacy)
+	}
+	return "", errors.Errorf("could not find virtiofsd (required for `mountType: virtiofs`), attempted %v", candidates)
+}
+
 func getAccel(arch limayaml.Arch) string {
 	nativeX8664 := arch == limayaml.X8664 && runtime.GOARCH == "amd64"
 	nativeAARCH64 := arch == limayaml.AARCH64 && runtime.GOARCH == "arm64"
@@ -0,0 +1,29 @@
+package qemu
+
+import (
+	"testing"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestVirtiofsdCmdline(t *testing.T) {
+	assert.Equal(t, false, isLegacyVirtiofsdVersion("virtiofsd 1.10.1\n"))
+	assert.Equal(t, true, isLegacyVirtiofsdVersion("virtiofsd version 6.2.0 (Debian 1:6.2+dfsg-2ubuntu6)\ncopyright (c) 2003-2021 Fabrice Bellard and the QEMU Project developers\nusing FUSE kernel interface version 7.31\n"))
+
+	assert.DeepEqual(t,
+		[]string{"/usr/bin/virtiofsd", "--socket-path=/tmp/virtiofsd.sock", "--shared-dir=/home/foo", "--sandbox=none", "--cache=auto", "--readonly"},
+		virtiofsdCmdline("/usr/bin/virtiofsd", "/tmp/virtiofsd.sock", "/home/foo", false))
+	assert.DeepEqual(t,
+		[]string{"/usr/bin/virtiofsd", "--socket-path=/tmp/virtiofsd.sock", "--shared-dir=/home/foo", "--sandbox=none", "--cache=auto"},
+		virtiofsdCmdline("/usr/bin/virtiofsd", "/tmp/virtiofsd.sock", "/home/foo", true))
+}
+
+func TestValidateOnlineSnapshot(t *testing.T) {
+	y := &limayaml.LimaYAML{
+		Mounts: []limayaml.Mount{{Location: "/foo", MountType: limayaml.ReverseSSHFS}},
+	}
+	assert.NilError(t, ValidateOnlineSnapshot(y))
+	y.Mounts = append(y.Mounts, limayaml.Mount{Location: "/bar", MountType: limayaml.NineP})
+	assert.ErrorContains(t, ValidateOnlineSnapshot(y), "stop the instance")
+}
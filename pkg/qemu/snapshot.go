@@ -0,0 +1,153 @@
+package qemu
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/containerd/containerd/identifiers"
+	"github.com/digitalocean/go-qemu/qmp"
+	"github.com/digitalocean/go-qemu/qmp/raw"
+	"github.com/pkg/errors"
+)
+
+// Snapshot is an internal snapshot of the qcow2 diffdisk,
+// as in the "snapshots" field of `qemu-img info --output=json`.
+type Snapshot struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	VMStateSize int64  `json:"vm-state-size"` // 0 for offline snapshots
+	DateSec     int64  `json:"date-sec"`
+	DateNsec    int64  `json:"date-nsec"`
+}
+
+// Date returns the creation date of the snapshot.
+func (s *Snapshot) Date() time.Time {
+	return time.Unix(s.DateSec, s.DateNsec)
+}
+
+// ValidateSnapshotTag validates the snapshot tag.
+// Numeric tags are rejected, as QEMU would confuse them with the snapshot IDs.
+func ValidateSnapshotTag(tag string) error {
+	if err := identifiers.Validate(tag); err != nil {
+		return err
+	}
+	if _, err := strconv.Atoi(tag); err == nil {
+		return errors.Errorf("snapshot tag %q must not be a number", tag)
+	}
+	return nil
+}
+
+// ListSnapshots lists the snapshots of the diffdisk.
+// ListSnapshots can be called for both running and stopped instances.
+func ListSnapshots(instDir string) ([]Snapshot, error) {
+	diffDisk, err := diffDiskPath(instDir)
+	if err != nil {
+		return nil, err
+	}
+	// -U (--force-share) is needed for reading the disk of a running instance
+	cmd := exec.Command("qemu-img", "info", "--output=json", "-U", diffDisk)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run %v: %q", cmd.Args, stderrOf(err))
+	}
+	var info struct {
+		Snapshots []Snapshot `json:"snapshots,omitempty"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the output of %v", cmd.Args)
+	}
+	return info.Snapshots, nil
+}
+
+// ValidateOnlineSnapshot checks that the snapshots of the running instance can be created and applied.
+// QEMU cannot save nor load the VM state when 9p or virtiofs devices are attached, as they block migration.
+func ValidateOnlineSnapshot(y *limayaml.LimaYAML) error {
+	for _, t := range []limayaml.MountType{limayaml.NineP, limayaml.VirtIOFS} {
+		if hasMountType(y, t) {
+			return errors.Errorf("snapshots of a running instance are not supported for the mounts of type %q (hint: stop the instance first)", t)
+		}
+	}
+	return nil
+}
+
+// CreateSnapshot creates the snapshot tag.
+// When the instance is running, the snapshot is created via QMP, including the VM state.
+func CreateSnapshot(instDir string, running bool, tag string) error {
+	return snapshot(instDir, running, tag, "-c", "savevm")
+}
+
+// ApplySnapshot reverts the instance to the snapshot tag.
+// When the instance is running, the snapshot is applied via QMP, including the VM state
+// (if the snapshot has the VM state).
+func ApplySnapshot(instDir string, running bool, tag string) error {
+	return snapshot(instDir, running, tag, "-a", "loadvm")
+}
+
+// DeleteSnapshot deletes the snapshot tag.
+func DeleteSnapshot(instDir string, running bool, tag string) error {
+	return snapshot(instDir, running, tag, "-d", "delvm")
+}
+
+func snapshot(instDir string, running bool, tag, qemuImgFlag, hmpCommand string) error {
+	if err := ValidateSnapshotTag(tag); err != nil {
+		return err
+	}
+	if running {
+		return humanMonitorCommand(instDir, hmpCommand+" "+tag)
+	}
+	diffDisk, err := diffDiskPath(instDir)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("qemu-img", "snapshot", qemuImgFlag, tag, diffDisk)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to run %v: %q", cmd.Args, string(out))
+	}
+	return nil
+}
+
+// humanMonitorCommand executes an HMP command via the QMP socket.
+func humanMonitorCommand(instDir, command string) error {
+	qmpSockPath := filepath.Join(instDir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open the QMP socket %q", qmpSockPath)
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return errors.Wrapf(err, "failed to connect to the QMP socket %q", qmpSockPath)
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	out, err := rawClient.HumanMonitorCommand(command, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to execute %q via the QMP socket %q", command, qmpSockPath)
+	}
+	// HMP commands print nothing on success
+	if out = strings.TrimSpace(out); out != "" {
+		return errors.Errorf("failed to execute %q: %s", command, out)
+	}
+	return nil
+}
+
+func diffDiskPath(instDir string) (string, error) {
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	if _, err := os.Stat(diffDisk); err != nil {
+		return "", err
+	}
+	return diffDisk, nil
+}
+
+func stderrOf(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(exitErr.Stderr)
+	}
+	return ""
+}
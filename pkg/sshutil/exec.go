@@ -0,0 +1,111 @@
+package sshutil
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/AkihiroSuda/lima/pkg/envutil"
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ExecOptions are the options for Exec.
+type ExecOptions struct {
+	// Argv is the command to execute in the guest. Argv is executed without a login shell.
+	Argv []string
+	// User is the guest user to execute Argv as, with `sudo -n`. Empty means the default user of the instance.
+	User string
+	// Env is the list of the environment variables ("KEY=VALUE").
+	Env []string
+	// TTY allocates a pseudo-terminal in the guest.
+	TTY    bool
+	Stdin  io.Reader // nil means /dev/null
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ExecRemoteCommand returns the command line executed by sshd in the guest for opts.
+func ExecRemoteCommand(opts ExecOptions) (string, error) {
+	if len(opts.Argv) == 0 {
+		return "", errors.New("empty argv")
+	}
+	var argv []string
+	if opts.User != "" {
+		argv = append(argv, "sudo", "-n", "-u", opts.User, "--")
+	}
+	if len(opts.Env) > 0 {
+		for _, kv := range opts.Env {
+			if _, _, err := envutil.ParseKeyValue(kv); err != nil {
+				return "", err
+			}
+		}
+		argv = append(argv, "env")
+		argv = append(argv, opts.Env...)
+	}
+	argv = append(argv, opts.Argv...)
+	// sshd passes the command line to the shell of the user (`sh -c`), not to a login shell
+	return shellescape.QuoteCommand(argv), nil
+}
+
+// Exec executes opts.Argv in the guest via the ssh master of the instance (see SSHArgs), and returns the exit code.
+// err is non-nil when ssh could not be executed, or could not connect to the guest.
+func Exec(ctx context.Context, instDir string, sshLocalPort int, opts ExecOptions) (int, error) {
+	remoteCommand, err := ExecRemoteCommand(opts)
+	if err != nil {
+		return -1, err
+	}
+	arg0, err := exec.LookPath("ssh")
+	if err != nil {
+		return -1, err
+	}
+	baseArgs, err := SSHArgs(instDir)
+	if err != nil {
+		return -1, err
+	}
+	// LogLevel=ERROR suppresses "Connection to 127.0.0.1 closed." of "-tt", but not the connection errors
+	baseArgs = append(baseArgs, "-o", "LogLevel=ERROR", "-p", strconv.Itoa(sshLocalPort))
+	args := append([]string{}, baseArgs...)
+	if opts.TTY {
+		// "-tt" forces the allocation even when opts.Stdin is not a terminal
+		args = append(args, "-tt")
+	} else {
+		args = append(args, "-T")
+	}
+	args = append(args, "127.0.0.1", "--", remoteCommand)
+	cmd := exec.CommandContext(ctx, arg0, args...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	logrus.Debugf("executing ssh: %+v", cmd.Args)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+			return -1, err
+		}
+		exitCode := exitErr.ExitCode()
+		// ssh exits with 255 on its own errors, so the connection is checked to tell them from the exit code of the command
+		if exitCode == sshErrorExitCode {
+			if connErr := checkConnection(ctx, arg0, baseArgs); connErr != nil {
+				return -1, connErr
+			}
+		}
+		return exitCode, nil
+	}
+	return 0, nil
+}
+
+const sshErrorExitCode = 255
+
+// checkConnection checks that ssh can connect to the guest, by executing `true`.
+func checkConnection(ctx context.Context, arg0 string, baseArgs []string) error {
+	args := append(append([]string{}, baseArgs...), "-T", "127.0.0.1", "--", "true")
+	cmd := exec.CommandContext(ctx, arg0, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to connect to the guest via ssh: %q", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package sshutil
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestExecRemoteCommand(t *testing.T) {
+	s, err := ExecRemoteCommand(ExecOptions{Argv: []string{"echo", "foo bar", "$HOME"}})
+	assert.NilError(t, err)
+	assert.Equal(t, `echo 'foo bar' '$HOME'`, s)
+
+	s, err = ExecRemoteCommand(ExecOptions{Argv: []string{"id", "-u"}, User: "root", Env: []string{"FOO=a b"}})
+	assert.NilError(t, err)
+	assert.Equal(t, `sudo -n -u root -- env 'FOO=a b' id -u`, s)
+
+	_, err = ExecRemoteCommand(ExecOptions{})
+	assert.ErrorContains(t, err, "empty argv")
+
+	_, err = ExecRemoteCommand(ExecOptions{Argv: []string{"true"}, Env: []string{"=foo"}})
+	assert.ErrorContains(t, err, "empty environment variable name")
+}
@@ -46,7 +46,9 @@ func DefaultPubKeys() []PubKey {
 	return res
 }
 
-func SSHArgs(instDir string) ([]string, error) {
+// SSHOpts returns the ssh options for the instance, in the form of "KEY=VALUE".
+// The options can be passed to ssh with `-o`, or written to ssh_config.
+func SSHOpts(instDir string) ([]string, error) {
 	controlSock := filepath.Join(instDir, filenames.SSHSock)
 	maxSockLen := 104
 	if runtime.GOOS == "linux" {
@@ -59,16 +61,33 @@ func SSHArgs(instDir string) ([]string, error) {
 		// So we do not use `~/Library/Application Support`.
 		return nil, errors.Errorf("socket path %q is too long: > UNIX_PATH_MAX=%d", controlSock, maxSockLen)
 	}
-	args := []string{
-		"-o", "ControlMaster=auto",
-		"-o", "ControlPath=" + controlSock,
-		"-o", "ControlPersist=5m",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "NoHostAuthenticationForLocalhost=yes",
-		"-o", "GSSAPIAuthentication=no",
-		"-o", "PreferredAuthentications=publickey",
-		"-o", "Compression=no",
-		"-o", "BatchMode=yes",
+	opts := []string{
+		"ControlMaster=auto",
+		"ControlPath=" + controlSock,
+		"ControlPersist=5m",
+		"StrictHostKeyChecking=no",
+		"NoHostAuthenticationForLocalhost=yes",
+		"GSSAPIAuthentication=no",
+		"PreferredAuthentications=publickey",
+		"Compression=no",
+		"BatchMode=yes",
+	}
+	return opts, nil
+}
+
+func SSHArgs(instDir string) ([]string, error) {
+	opts, err := SSHOpts(instDir)
+	if err != nil {
+		return nil, err
+	}
+	return SSHArgsFromOpts(opts), nil
+}
+
+// SSHArgsFromOpts converts the options returned by SSHOpts to the `-o KEY=VALUE` arguments.
+func SSHArgsFromOpts(opts []string) []string {
+	args := make([]string, 0, 2*len(opts))
+	for _, o := range opts {
+		args = append(args, "-o", o)
 	}
-	return args, nil
+	return args
 }
@@ -6,10 +6,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/AkihiroSuda/lima/pkg/cidata"
+	"github.com/AkihiroSuda/lima/pkg/downloader"
 	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	hostagentclient "github.com/AkihiroSuda/lima/pkg/hostagent/api/client"
+	"github.com/AkihiroSuda/lima/pkg/hostagent/dns"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
 	"github.com/AkihiroSuda/lima/pkg/qemu"
 	"github.com/AkihiroSuda/lima/pkg/store"
@@ -18,9 +22,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func ensureDisk(ctx context.Context, instName, instDir string, y *limayaml.LimaYAML) error {
+func ensureDisk(ctx context.Context, instName, instDir string, y *limayaml.LimaYAML, hostResolverPort int, limaNetworks map[string]cidata.LimaNetwork) error {
+	progress := downloader.WithProgress(downloader.TextProgress(os.Stderr))
 	cidataISOPath := filepath.Join(instDir, filenames.CIDataISO)
-	if err := cidata.GenerateISO9660(cidataISOPath, instName, y); err != nil {
+	if err := cidata.GenerateISO9660(cidataISOPath, instName, y, hostResolverPort, limaNetworks, progress); err != nil {
 		return err
 	}
 	qCfg := qemu.Config{
@@ -28,7 +33,7 @@ func ensureDisk(ctx context.Context, instName, instDir string, y *limayaml.LimaY
 		InstanceDir: instDir,
 		LimaYAML:    y,
 	}
-	if err := qemu.EnsureDisk(qCfg); err != nil {
+	if err := qemu.EnsureDisk(qCfg, progress); err != nil {
 		return err
 	}
 
@@ -46,7 +51,44 @@ func Start(ctx context.Context, inst *store.Instance) error {
 		return err
 	}
 
-	if err := ensureDisk(ctx, inst.Name, inst.Dir, y); err != nil {
+	disks, err := store.AdditionalDisks(y)
+	if err != nil {
+		return err
+	}
+	for _, d := range disks {
+		if d.Instance != "" && d.Instance != inst.Name {
+			return errors.Errorf("disk %q is in use by instance %q", d.Name, d.Instance)
+		}
+	}
+
+	limaNetworks, err := store.LimaNetworks(y)
+	if err != nil {
+		return err
+	}
+	if len(limaNetworks) > 0 {
+		if err := qemu.CheckLoopbackMulticast(); err != nil {
+			return err
+		}
+	}
+	cidataNetworks := make(map[string]cidata.LimaNetwork)
+	for name, nw := range limaNetworks {
+		addr, err := nw.AllocateAddress(inst.Name)
+		if err != nil {
+			return err
+		}
+		cidataNetworks[name] = cidata.LimaNetwork{Address: addr, Addresses: nw.Addresses}
+	}
+
+	// The port of the DNS forwarder has to be known to the cidata, so it is assigned here rather than in the host agent
+	var hostResolverPort int
+	if *y.HostResolver.Enabled {
+		hostResolverPort, err = dns.FindFreePort()
+		if err != nil {
+			return errors.Wrap(err, "failed to assign a port for `hostResolver`")
+		}
+	}
+
+	if err := ensureDisk(ctx, inst.Name, inst.Dir, y, hostResolverPort, cidataNetworks); err != nil {
 		return err
 	}
 
@@ -73,22 +115,32 @@ func Start(ctx context.Context, inst *store.Instance) error {
 	}
 	// no defer haStderrW.Close()
 
-	haCmd := exec.CommandContext(ctx, self,
+	haArgs := []string{
 		"hostagent",
 		"--pidfile", haPIDPath,
-		inst.Name)
+	}
+	if hostResolverPort != 0 {
+		haArgs = append(haArgs, "--dns-port", strconv.Itoa(hostResolverPort))
+	}
+	haArgs = append(haArgs, inst.Name)
+	haCmd := exec.CommandContext(ctx, self, haArgs...)
 	haCmd.Stdout = haStdoutW
 	haCmd.Stderr = haStderrW
 
 	if err := haCmd.Start(); err != nil {
 		return err
 	}
+	haWaitCh := make(chan error, 1)
+	go func() {
+		haWaitCh <- haCmd.Wait()
+	}()
 
 	if err := waitHostAgentStart(ctx, haPIDPath, haStderrPath); err != nil {
 		return err
 	}
 
-	return watchHostAgentEvents(ctx, inst.Name, haStdoutPath, haStderrPath)
+	haSock := filepath.Join(inst.Dir, filenames.HostAgentSock)
+	return watchHostAgentEvents(ctx, inst.Name, haSock, haStderrPath, haWaitCh)
 	// leave the hostagent process running
 }
 
@@ -106,16 +158,45 @@ func waitHostAgentStart(ctx context.Context, haPIDPath, haStderrPath string) err
 	}
 }
 
-func watchHostAgentEvents(ctx context.Context, instName, haStdoutPath, haStderrPath string) error {
+// connectHostAgent waits for the host agent API to be available.
+// haWaitCh receives the result of the host agent process.
+func connectHostAgent(ctx context.Context, haSock, haStderrPath string, haWaitCh <-chan error) (hostagentclient.HostAgentClient, error) {
+	for {
+		haClient, err := hostagentclient.Connect(ctx, haSock)
+		if err == nil {
+			return haClient, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(err, "failed to connect to the host agent API %q (hint: see %q)", haSock, haStderrPath)
+		case haErr := <-haWaitCh:
+			return nil, errors.Errorf("the host agent exited before the API became available: %v (hint: see %q)", haErr, haStderrPath)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func watchHostAgentEvents(ctx context.Context, instName, haSock, haStderrPath string, haWaitCh <-chan error) error {
 	ctx2, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
+	go func() {
+		if logsErr := hostagentapi.PropagateLogs(ctx2, haStderrPath); logsErr != nil {
+			logrus.WithError(logsErr).Warnf("failed to read the logs of the host agent %q", haStderrPath)
+		}
+	}()
+
+	haClient, err := connectHostAgent(ctx2, haSock, haStderrPath, haWaitCh)
+	if err != nil {
+		return err
+	}
+
 	var (
 		printedSSHLocalPort  bool
 		receivedRunningEvent bool
-		err                  error
 	)
 	onEvent := func(ev hostagentapi.Event) bool {
+		logrus.WithField("event", ev).Debugf("received an event")
 		if !printedSSHLocalPort && ev.Status.SSHLocalPort != 0 {
 			logrus.Infof("SSH Local Port: %d", ev.Status.SSHLocalPort)
 			printedSSHLocalPort = true
@@ -130,7 +211,7 @@ func watchHostAgentEvents(ctx context.Context, instName, haStdoutPath, haStderrP
 		} else if ev.Status.Running {
 			receivedRunningEvent = true
 			if ev.Status.Degraded {
-				logrus.Warnf("DEGRADED. The VM seems running, but file sharing and port forwarding may not work. (hint: see %q)", haStderrPath)
+				logrus.Warnf("DEGRADED. The VM seems running, but file sharing, port forwarding, or provisioning may not work. (hint: see %q)", haStderrPath)
 				err = errors.Errorf("degraded, status=%+v", ev.Status)
 				return true
 			}
@@ -142,8 +223,8 @@ func watchHostAgentEvents(ctx context.Context, instName, haStdoutPath, haStderrP
 		return false
 	}
 
-	if xerr := hostagentapi.WatchEvents(ctx2, haStdoutPath, haStderrPath, onEvent); xerr != nil {
-		return xerr
+	if xerr := haClient.Events(ctx2, onEvent); xerr != nil && !receivedRunningEvent && err == nil {
+		return errors.Wrapf(xerr, "failed to watch the host agent events (hint: see %q)", haStderrPath)
 	}
 
 	if err != nil {
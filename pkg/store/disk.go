@@ -0,0 +1,205 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/qemu"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/containerd/containerd/identifiers"
+)
+
+// DisksDir is a directory that appears under LimaDir.
+const DisksDir = "_disks"
+
+type Disk struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Dir      string `json:"dir"`
+	Instance string `json:"instance,omitempty"` // the instance that has locked the disk
+}
+
+// Path returns the path of the disk image.
+func (d *Disk) Path() string {
+	return filepath.Join(d.Dir, filenames.DataDisk)
+}
+
+// DiskDir returns the disk dir.
+// DiskDir does not check whether the disk exists
+func DiskDir(name string) (string, error) {
+	if err := identifiers.Validate(name); err != nil {
+		return "", err
+	}
+	limaDir, err := LimaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(limaDir, DisksDir, name), nil
+}
+
+// Disks returns the names of the disks under LimaDir.
+func Disks() ([]string, error) {
+	limaDir, err := LimaDir()
+	if err != nil {
+		return nil, err
+	}
+	disksDirList, err := os.ReadDir(filepath.Join(limaDir, DisksDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, f := range disksDirList {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	return names, nil
+}
+
+// InspectDisk returns os.ErrNotExist when the disk does not exist.
+func InspectDisk(name string) (*Disk, error) {
+	dir, err := DiskDir(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		Name: name,
+		Dir:  dir,
+	}
+	if _, err := os.Stat(d.Path()); err != nil {
+		return nil, err
+	}
+	d.Size, err = qemu.DiskVirtualSize(d.Path())
+	if err != nil {
+		return nil, err
+	}
+	d.Instance, err = d.lockHolder()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// lockHolder returns the name of the instance that has locked the disk.
+// Locks held by the instances whose host agent is not running (stopped or removed) are stale, and ignored.
+// The status of the instance is not checked, as QEMU is not started yet when the host agent locks the disk.
+func (d *Disk) lockHolder() (string, error) {
+	b, err := os.ReadFile(filepath.Join(d.Dir, filenames.InUseBy))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	instName := strings.TrimSpace(string(b))
+	inst, err := Inspect(instName)
+	if err != nil || inst.HostAgentPID == 0 {
+		return "", nil
+	}
+	if err := syscall.Kill(inst.HostAgentPID, 0); errors.Is(err, syscall.ESRCH) {
+		return "", nil
+	}
+	return instName, nil
+}
+
+// Lock locks the disk for the instance.
+// Lock fails if the disk is locked by another instance whose host agent is running.
+func (d *Disk) Lock(instName string) error {
+	inUseBy := filepath.Join(d.Dir, filenames.InUseBy)
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(inUseBy, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(instName)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		holder, err := d.lockHolder()
+		if err != nil {
+			return err
+		}
+		if holder != "" && holder != instName {
+			return fmt.Errorf("disk %q is in use by instance %q", d.Name, holder)
+		}
+		// stale lock, or already locked by instName itself
+		if err := os.Remove(inUseBy); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to lock disk %q", d.Name)
+}
+
+// Unlock unlocks the disk, if it is locked by the instance.
+func (d *Disk) Unlock(instName string) error {
+	inUseBy := filepath.Join(d.Dir, filenames.InUseBy)
+	b, err := os.ReadFile(inUseBy)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(b)) != instName {
+		return nil
+	}
+	return os.Remove(inUseBy)
+}
+
+// AdditionalDisks inspects the disks specified in the `additionalDisks` field.
+func AdditionalDisks(y *limayaml.LimaYAML) ([]*Disk, error) {
+	var res []*Disk
+	for _, name := range y.AdditionalDisks {
+		d, err := InspectDisk(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("disk %q does not exist (hint: create it with `limactl disk create %s --size SIZE`)", name, name)
+			}
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// DiskUsers returns the names of the instances that have the disk in the `additionalDisks` field,
+// regardless of the status of the instances.
+func DiskUsers(diskName string) ([]string, error) {
+	instNames, err := Instances()
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, instName := range instNames {
+		instDir, err := InstanceDir(instName)
+		if err != nil {
+			return nil, err
+		}
+		y, err := LoadYAMLByFilePath(filepath.Join(instDir, filenames.LimaYAML))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load the YAML of instance %q: %w", instName, err)
+		}
+		for _, f := range y.AdditionalDisks {
+			if f == diskName {
+				users = append(users, instName)
+				break
+			}
+		}
+	}
+	return users, nil
+}
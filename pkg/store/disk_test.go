@@ -0,0 +1,88 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestDiskLock(t *testing.T) {
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	assert.NilError(t, os.Setenv("HOME", t.TempDir()))
+	diskDir, err := DiskDir("data")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(diskDir, 0700))
+	d := &Disk{Name: "data", Dir: diskDir}
+
+	// "foo" does not exist, so the lock is stale
+	assert.NilError(t, d.Lock("foo"))
+	assert.NilError(t, d.Lock("bar"))
+
+	// "bar" is running (the PID of the test process is used as the PID of the host agent)
+	barDir, err := InstanceDir("bar")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(barDir, 0700))
+	for f, content := range map[string]string{
+		filenames.LimaYAML:     "images: [{location: /dummy.img}]\n",
+		filenames.HostAgentPID: strconv.Itoa(os.Getpid()) + "\n",
+		filenames.QemuPID:      "43\n",
+	} {
+		assert.NilError(t, os.WriteFile(filepath.Join(barDir, f), []byte(content), 0644))
+	}
+	holder, err := d.lockHolder()
+	assert.NilError(t, err)
+	assert.Equal(t, "bar", holder)
+	assert.ErrorContains(t, d.Lock("foo"), `disk "data" is in use by instance "bar"`)
+	assert.NilError(t, d.Lock("bar"))
+
+	// Unlock by others is ignored
+	assert.NilError(t, d.Unlock("foo"))
+	holder, err = d.lockHolder()
+	assert.NilError(t, err)
+	assert.Equal(t, "bar", holder)
+
+	// The host agent of "bar" is running but QEMU is not started yet, so the lock is not stale
+	assert.NilError(t, os.Remove(filepath.Join(barDir, filenames.QemuPID)))
+	holder, err = d.lockHolder()
+	assert.NilError(t, err)
+	assert.Equal(t, "bar", holder)
+	assert.ErrorContains(t, d.Lock("foo"), `disk "data" is in use by instance "bar"`)
+
+	// "bar" is stopped, so the lock is stale
+	assert.NilError(t, os.Remove(filepath.Join(barDir, filenames.HostAgentPID)))
+	holder, err = d.lockHolder()
+	assert.NilError(t, err)
+	assert.Equal(t, "", holder)
+	assert.NilError(t, d.Lock("foo"))
+	assert.NilError(t, d.Unlock("foo"))
+
+	assert.NilError(t, d.Unlock("bar"))
+	assert.NilError(t, d.Lock("foo"))
+}
+
+func TestDiskUsers(t *testing.T) {
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	assert.NilError(t, os.Setenv("HOME", t.TempDir()))
+	for instName, content := range map[string]string{
+		"foo": "images: [{location: /dummy.img}]\nadditionalDisks: [data]\n",
+		"bar": "images: [{location: /dummy.img}]\nadditionalDisks: [data, data2]\n",
+		"baz": "images: [{location: /dummy.img}]\n",
+	} {
+		instDir, err := InstanceDir(instName)
+		assert.NilError(t, err)
+		assert.NilError(t, os.MkdirAll(instDir, 0700))
+		assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.LimaYAML), []byte(content), 0644))
+	}
+	users, err := DiskUsers("data")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bar", "foo"}, users)
+	users, err = DiskUsers("data3")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(users))
+}
@@ -1,4 +1,5 @@
-// Package filenames defines the names of the files that appear under an instance dir.
+// Package filenames defines the names of the files that appear under an instance dir,
+// a disk dir, and a network dir.
 //
 // See docs/internal.md .
 package filenames
@@ -12,9 +13,24 @@ const (
 	QMPSock            = "qmp.sock"
 	SerialLog          = "serial.log"
 	SerialSock         = "serial.sock"
+	VirtiofsdSock      = "virtiofsd-%d.sock" // "%d" is the index of the mount
 	SSHSock            = "ssh.sock"
+	SSHLocalPort       = "ssh.localport"
 	GuestAgentSock     = "ga.sock"
 	HostAgentPID       = "ha.pid"
+	HostAgentSock      = "ha.sock"
 	HostAgentStdoutLog = "ha.stdout.log"
 	HostAgentStderrLog = "ha.stderr.log"
 )
+
+// Filenames that appear under a disk dir (~/.lima/_disks/<NAME>)
+const (
+	DataDisk = "datadisk"
+	InUseBy  = "in_use_by"
+)
+
+// Filenames that appear under a network dir (~/.lima/_networks/<NAME>)
+const (
+	NetworkYAML      = "network.yaml"
+	NetworkAddresses = "addresses"
+)
@@ -1,13 +1,19 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	hostagentapi "github.com/AkihiroSuda/lima/pkg/hostagent/api"
+	hostagentclient "github.com/AkihiroSuda/lima/pkg/hostagent/api/client"
 	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/qemu"
 	"github.com/AkihiroSuda/lima/pkg/store/filenames"
 )
 
@@ -25,10 +31,16 @@ type Instance struct {
 	Status       Status        `json:"status"`
 	Dir          string        `json:"dir"`
 	Arch         limayaml.Arch `json:"arch"`
-	SSHLocalPort int           `json:"sshLocalPort,omitempty"`
+	SSHLocalPort int           `json:"sshLocalPort,omitempty"` // 0 if not running and automatically assigned
 	HostAgentPID int           `json:"hostAgentPID,omitempty"`
 	QemuPID      int           `json:"qemuPID,omitempty"`
-	Errors       []error       `json:"errors,omitempty"`
+	Snapshots    []string      `json:"snapshots,omitempty"` // tags of the snapshots of the diffdisk, filled by LoadSnapshots
+	// Degraded, DegradedMounts, and IPAddresses are read from the latest event of the host agent
+	Degraded       bool     `json:"degraded,omitempty"`
+	DegradedMounts []string `json:"degradedMounts,omitempty"`
+	// IPAddresses are the guest IP addresses on the networks other than the user network
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+	Errors      []error  `json:"errors,omitempty"`
 }
 
 func (inst *Instance) LoadYAML() (*limayaml.LimaYAML, error) {
@@ -64,13 +76,13 @@ func Inspect(instName string) (*Instance, error) {
 	inst.Arch = y.Arch
 	inst.SSHLocalPort = y.SSH.LocalPort
 
-	inst.HostAgentPID, err = readPIDFile(filepath.Join(instDir, filenames.HostAgentPID))
+	inst.HostAgentPID, err = readIntFile(filepath.Join(instDir, filenames.HostAgentPID))
 	if err != nil {
 		inst.Status = StatusBroken
 		inst.Errors = append(inst.Errors, err)
 	}
 
-	inst.QemuPID, err = readPIDFile(filepath.Join(instDir, filenames.QemuPID))
+	inst.QemuPID, err = readIntFile(filepath.Join(instDir, filenames.QemuPID))
 	if err != nil {
 		inst.Status = StatusBroken
 		inst.Errors = append(inst.Errors, err)
@@ -90,11 +102,50 @@ func Inspect(instName string) (*Instance, error) {
 		}
 	}
 
+	if inst.Status == StatusRunning && inst.SSHLocalPort == 0 {
+		// ssh.localPort was automatically assigned by the host agent
+		inst.SSHLocalPort, err = readIntFile(filepath.Join(instDir, filenames.SSHLocalPort))
+		if err != nil {
+			inst.Errors = append(inst.Errors, err)
+		}
+	}
+
+	if inst.Status == StatusRunning {
+		st, err := hostAgentStatus(filepath.Join(instDir, filenames.HostAgentSock))
+		if err != nil {
+			inst.Errors = append(inst.Errors, err)
+		} else if st != nil {
+			inst.Degraded = st.Degraded
+			inst.DegradedMounts = st.DegradedMounts
+			inst.IPAddresses = st.IPAddresses
+		}
+	}
+
 	return inst, nil
 }
 
-// readPIDFile returns 0 if the PID file does not exist
-func readPIDFile(path string) (int, error) {
+// LoadSnapshots fills inst.Snapshots.
+// LoadSnapshots is not called by Inspect, as it executes `qemu-img`.
+func (inst *Instance) LoadSnapshots() error {
+	inst.Snapshots = nil
+	if _, err := os.Stat(filepath.Join(inst.Dir, filenames.DiffDisk)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	snapshots, err := qemu.ListSnapshots(inst.Dir)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		inst.Snapshots = append(inst.Snapshots, snapshot.Name)
+	}
+	return nil
+}
+
+// readIntFile returns 0 if the file (e.g., PID file) does not exist
+func readIntFile(path string) (int, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -104,3 +155,23 @@ func readPIDFile(path string) (int, error) {
 	}
 	return strconv.Atoi(strings.TrimSpace(string(b)))
 }
+
+// hostAgentStatus returns the status of the latest event of the running host agent,
+// or nil if the host agent API is not available yet.
+func hostAgentStatus(haSock string) (*hostagentapi.Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := os.Stat(haSock); errors.Is(err, os.ErrNotExist) {
+		// The host agent is starting up
+		return nil, nil
+	}
+	haClient, err := hostagentclient.NewHostAgentClient(haSock)
+	if err != nil {
+		return nil, err
+	}
+	info, err := haClient.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the status from the host agent API %q: %w", haSock, err)
+	}
+	return &info.Status, nil
+}
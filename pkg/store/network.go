@@ -0,0 +1,272 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AkihiroSuda/lima/pkg/limayaml"
+	"github.com/AkihiroSuda/lima/pkg/store/filenames"
+	"github.com/containerd/containerd/identifiers"
+	"gopkg.in/yaml.v2"
+)
+
+// NetworksDir is a directory that appears under LimaDir.
+const NetworksDir = "_networks"
+
+// Network is a host-local virtual network created with `limactl network create`.
+// The instances on the network are connected with QEMU `-netdev socket,mcast=...`.
+type Network struct {
+	Name             string `yaml:"-" json:"name"`
+	Dir              string `yaml:"-" json:"dir"`
+	Subnet           string `yaml:"subnet" json:"subnet"`                     // e.g., "192.168.106.0/24"
+	MulticastAddress string `yaml:"multicastAddress" json:"multicastAddress"` // e.g., "239.255.12.34:12345"
+	// Addresses are the addresses assigned to the instances, keyed by the instance names
+	Addresses map[string]string `yaml:"-" json:"addresses,omitempty"`
+}
+
+// NetworkDir returns the network dir.
+// NetworkDir does not check whether the network exists
+func NetworkDir(name string) (string, error) {
+	if err := identifiers.Validate(name); err != nil {
+		return "", err
+	}
+	limaDir, err := LimaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(limaDir, NetworksDir, name), nil
+}
+
+// Networks returns the names of the networks under LimaDir.
+func Networks() ([]string, error) {
+	limaDir, err := LimaDir()
+	if err != nil {
+		return nil, err
+	}
+	networksDirList, err := os.ReadDir(filepath.Join(limaDir, NetworksDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, f := range networksDirList {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	return names, nil
+}
+
+// InspectNetwork returns os.ErrNotExist when the network does not exist.
+func InspectNetwork(name string) (*Network, error) {
+	dir, err := NetworkDir(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, filenames.NetworkYAML))
+	if err != nil {
+		return nil, err
+	}
+	nw := &Network{
+		Name:      name,
+		Dir:       dir,
+		Addresses: make(map[string]string),
+	}
+	if err := yaml.Unmarshal(b, nw); err != nil {
+		return nil, err
+	}
+	addrsDir := filepath.Join(dir, filenames.NetworkAddresses)
+	addrsDirList, err := os.ReadDir(addrsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range addrsDirList {
+		instName, err := os.ReadFile(filepath.Join(addrsDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		nw.Addresses[strings.TrimSpace(string(instName))] = f.Name()
+	}
+	return nw, nil
+}
+
+// CreateNetwork creates a network.
+// When subnet is empty, an unused subnet is chosen from 192.168.106.0/24 - 192.168.254.0/24.
+func CreateNetwork(name, subnet string) (*Network, error) {
+	dir, err := NetworkDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("network %q already exists (%q)", name, dir)
+	}
+	existingNames, err := Networks()
+	if err != nil {
+		return nil, err
+	}
+	usedSubnets := make(map[string]bool)
+	usedMulticastAddresses := make(map[string]bool)
+	for _, f := range existingNames {
+		existing, err := InspectNetwork(f)
+		if err != nil {
+			continue
+		}
+		usedSubnets[existing.Subnet] = true
+		usedMulticastAddresses[existing.MulticastAddress] = true
+	}
+	if subnet == "" {
+		for i := 106; i < 255 && subnet == ""; i++ {
+			if candidate := fmt.Sprintf("192.168.%d.0/24", i); !usedSubnets[candidate] {
+				subnet = candidate
+			}
+		}
+		if subnet == "" {
+			return nil, errors.New("no unused subnet was found, specify the subnet explicitly")
+		}
+	}
+	if _, ipNet, err := net.ParseCIDR(subnet); err != nil {
+		return nil, err
+	} else if ones, bits := ipNet.Mask.Size(); bits != 32 || ones > 29 {
+		return nil, fmt.Errorf("subnet %q must be an IPv4 subnet with the prefix length <= 29", subnet)
+	} else {
+		subnet = ipNet.String()
+	}
+	nw := &Network{
+		Name:      name,
+		Dir:       dir,
+		Subnet:    subnet,
+		Addresses: make(map[string]string),
+	}
+	// The multicast address is derived from the name, so that it is unlikely to collide with other users
+	h := sha256.Sum256([]byte(name))
+	for i := 0; nw.MulticastAddress == "" || usedMulticastAddresses[nw.MulticastAddress]; i++ {
+		port := 10000 + (int(binary.BigEndian.Uint16(h[2:4]))+i)%50000
+		// 239.255.0.0/16 is the IPv4 Local Scope (RFC 2365)
+		nw.MulticastAddress = fmt.Sprintf("239.255.%d.%d:%d", h[0], h[1], port)
+	}
+	b, err := yaml.Marshal(nw)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, filenames.NetworkYAML), b, 0644); err != nil {
+		return nil, err
+	}
+	return nw, nil
+}
+
+// AllocateAddress returns the address of the instance, in the CIDR notation (e.g., "192.168.106.123/24").
+// The address is allocated on the first call, and persisted until ReleaseAddress is called.
+// The address is derived from the instance name when it is not used by other instances.
+func (nw *Network) AllocateAddress(instName string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(nw.Subnet)
+	if err != nil {
+		return "", err
+	}
+	if addr, err := nw.Address(instName); err == nil {
+		return addr, nil
+	}
+	ones, bits := ipNet.Mask.Size()
+	addrsDir := filepath.Join(nw.Dir, filenames.NetworkAddresses)
+	if err := os.MkdirAll(addrsDir, 0700); err != nil {
+		return "", err
+	}
+	// Excludes the network address, the broadcast address, and ".1" (reserved for the host)
+	size := uint32(1)<<uint(bits-ones) - 3
+	h := sha256.Sum256([]byte(instName))
+	start := binary.BigEndian.Uint32(h[:4]) % size
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	for i := uint32(0); i < size; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+2+(start+i)%size)
+		f, err := os.OpenFile(filepath.Join(addrsDir, ip.String()), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			return "", err
+		}
+		_, err = f.WriteString(instName)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		nw.Addresses[instName] = ip.String()
+		return nw.Address(instName)
+	}
+	return "", fmt.Errorf("no address is available in network %q (%s)", nw.Name, nw.Subnet)
+}
+
+// Address returns the address of the instance allocated by AllocateAddress, in the CIDR notation.
+func (nw *Network) Address(instName string) (string, error) {
+	ip, ok := nw.Addresses[instName]
+	if !ok {
+		return "", fmt.Errorf("no address is allocated for instance %q in network %q", instName, nw.Name)
+	}
+	_, ipNet, err := net.ParseCIDR(nw.Subnet)
+	if err != nil {
+		return "", err
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+// ReleaseAddress releases the address of the instance.
+func (nw *Network) ReleaseAddress(instName string) error {
+	ip, ok := nw.Addresses[instName]
+	if !ok {
+		return nil
+	}
+	delete(nw.Addresses, instName)
+	return os.RemoveAll(filepath.Join(nw.Dir, filenames.NetworkAddresses, ip))
+}
+
+// ReleaseAddresses releases the addresses of the instance in all the networks.
+func ReleaseAddresses(instName string) error {
+	names, err := Networks()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		nw, err := InspectNetwork(name)
+		if err != nil {
+			return err
+		}
+		if err := nw.ReleaseAddress(instName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LimaNetworks inspects the networks of type "lima" in the `networks` field.
+func LimaNetworks(y *limayaml.LimaYAML) (map[string]*Network, error) {
+	res := make(map[string]*Network)
+	for _, f := range y.Networks {
+		if f.Type != limayaml.NetworkLima {
+			continue
+		}
+		nw, err := InspectNetwork(f.Network)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("network %q does not exist (hint: create it with `limactl network create %s`)", f.Network, f.Network)
+			}
+			return nil, err
+		}
+		res[f.Network] = nw
+	}
+	return res, nil
+}
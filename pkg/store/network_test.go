@@ -0,0 +1,58 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNetworkAddress(t *testing.T) {
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	assert.NilError(t, os.Setenv("HOME", t.TempDir()))
+
+	nw, err := CreateNetwork("foo", "")
+	assert.NilError(t, err)
+	assert.Equal(t, "192.168.106.0/24", nw.Subnet)
+	_, err = CreateNetwork("foo", "")
+	assert.ErrorContains(t, err, "already exists")
+	bar, err := CreateNetwork("bar", "")
+	assert.NilError(t, err)
+	assert.Equal(t, "192.168.107.0/24", bar.Subnet)
+	_, err = CreateNetwork("baz", "192.168.200.0/30")
+	assert.ErrorContains(t, err, "prefix length")
+
+	// A /29 subnet has 5 usable addresses
+	small, err := CreateNetwork("small", "192.168.200.8/29")
+	assert.NilError(t, err)
+	seen := make(map[string]bool)
+	for _, instName := range []string{"a", "b", "c", "d", "e"} {
+		addr, err := small.AllocateAddress(instName)
+		assert.NilError(t, err)
+		assert.Assert(t, !seen[addr], "duplicate address %q", addr)
+		assert.Assert(t, addr != "192.168.200.8/29" && addr != "192.168.200.9/29" && addr != "192.168.200.15/29", addr)
+		seen[addr] = true
+	}
+	_, err = small.AllocateAddress("f")
+	assert.ErrorContains(t, err, "no address is available")
+
+	// The allocation is persisted
+	addr, err := nw.AllocateAddress("a")
+	assert.NilError(t, err)
+	nw, err = InspectNetwork("foo")
+	assert.NilError(t, err)
+	addr2, err := nw.AllocateAddress("a")
+	assert.NilError(t, err)
+	assert.Equal(t, addr, addr2)
+
+	assert.NilError(t, ReleaseAddresses("a"))
+	nw, err = InspectNetwork("foo")
+	assert.NilError(t, err)
+	_, err = nw.Address("a")
+	assert.ErrorContains(t, err, "no address is allocated")
+	small, err = InspectNetwork("small")
+	assert.NilError(t, err)
+	_, err = small.AllocateAddress("f")
+	assert.NilError(t, err)
+}
@@ -0,0 +1,227 @@
+// Package udptunnel implements a tunnel of UDP datagrams over a stream connection,
+// such as the guest agent socket forwarded via SSH.
+//
+// SSH (`ssh -L`) cannot forward UDP, so the host agent sends the datagrams to the guest agent
+// over a stream, and the guest agent relays them to the UDP port in the guest.
+//
+// Each datagram is framed as a 2-byte big endian length followed by the payload.
+package udptunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// UpgradeProtocol is the value of the "Upgrade" HTTP header for starting a tunnel.
+const UpgradeProtocol = "lima-udp-tunnel"
+
+// MaxDatagramSize is the maximum size of a datagram payload.
+const MaxDatagramSize = 65535
+
+// IdleTimeout is the duration after which an idle session is closed.
+const IdleTimeout = 60 * time.Second
+
+// WriteFrame writes a datagram to w.
+func WriteFrame(w io.Writer, b []byte) error {
+	if len(b) > MaxDatagramSize {
+		return errors.Errorf("datagram too large: %d bytes", len(b))
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a datagram from r into buf, and returns the length of the datagram.
+// buf should have MaxDatagramSize bytes.
+func ReadFrame(r io.Reader, buf []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(buf) {
+		return 0, errors.Errorf("datagram too large: %d bytes", n)
+	}
+	return io.ReadFull(r, buf[:n])
+}
+
+// Serve relays the datagrams between the stream rw and the UDP address addr.
+// Serve is called on the guest side.
+// Serve returns when rw is closed, or when no datagram is transferred for IdleTimeout.
+func Serve(ctx context.Context, rw io.ReadWriter, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	errCh := make(chan error, 2)
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, err := ReadFrame(rw, buf)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			if err := conn.SetReadDeadline(time.Now().Add(IdleTimeout)); err != nil {
+				errCh <- err
+				return
+			}
+			n, err := conn.Read(buf)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if err := WriteFrame(rw, buf[:n]); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		var netErr net.Error
+		if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil
+		}
+		return err
+	}
+}
+
+// DialFunc opens a stream connected to Serve on the guest side.
+type DialFunc func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// Forwarder listens on a UDP address on the host, and relays the datagrams over the streams.
+// Forwarder is used on the host side.
+//
+// A stream is opened for each of the clients (source addresses), so that the responses
+// can be sent back to the clients.
+type Forwarder struct {
+	l        *logrus.Logger
+	conn     net.PacketConn
+	dial     DialFunc
+	sessions map[string]io.ReadWriteCloser
+	mu       sync.Mutex
+	closed   chan struct{}
+}
+
+// NewForwarder listens on the UDP address hostAddr, and starts forwarding the datagrams to the streams opened by dial.
+func NewForwarder(l *logrus.Logger, hostAddr string, dial DialFunc) (*Forwarder, error) {
+	conn, err := net.ListenPacket("udp", hostAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{
+		l:        l,
+		conn:     conn,
+		dial:     dial,
+		sessions: make(map[string]io.ReadWriteCloser),
+		closed:   make(chan struct{}),
+	}
+	go f.loop()
+	return f, nil
+}
+
+// LocalAddr returns the UDP address on the host.
+func (f *Forwarder) LocalAddr() net.Addr {
+	return f.conn.LocalAddr()
+}
+
+func (f *Forwarder) loop() {
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, clientAddr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-f.closed:
+			default:
+				f.l.WithError(err).Warnf("failed to read UDP datagrams on %s", f.conn.LocalAddr())
+			}
+			return
+		}
+		stream, err := f.session(clientAddr)
+		if err != nil {
+			f.l.WithError(err).Warnf("failed to open a UDP tunnel for %s", clientAddr)
+			continue
+		}
+		if err := WriteFrame(stream, buf[:n]); err != nil {
+			f.l.WithError(err).Debugf("failed to send a UDP datagram from %s", clientAddr)
+			f.closeSession(clientAddr.String(), stream)
+		}
+	}
+}
+
+func (f *Forwarder) session(clientAddr net.Addr) (io.ReadWriteCloser, error) {
+	k := clientAddr.String()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if stream, ok := f.sessions[k]; ok {
+		return stream, nil
+	}
+	stream, err := f.dial(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	f.sessions[k] = stream
+	go func() {
+		defer f.closeSession(k, stream)
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, err := ReadFrame(stream, buf)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					f.l.WithError(err).Debugf("UDP tunnel for %s was closed", k)
+				}
+				return
+			}
+			if _, err := f.conn.WriteTo(buf[:n], clientAddr); err != nil {
+				f.l.WithError(err).Debugf("failed to send a UDP datagram to %s", k)
+				return
+			}
+		}
+	}()
+	return stream, nil
+}
+
+func (f *Forwarder) closeSession(k string, stream io.ReadWriteCloser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sessions[k] == stream {
+		delete(f.sessions, k)
+	}
+	_ = stream.Close()
+}
+
+// Close stops listening on the UDP address, and closes all the streams.
+func (f *Forwarder) Close() error {
+	close(f.closed)
+	err := f.conn.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, stream := range f.sessions {
+		_ = stream.Close()
+		delete(f.sessions, k)
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package udptunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gotest.tools/v3/assert"
+)
+
+func TestForwarder(t *testing.T) {
+	// "guest" UDP echo server
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		hostSide, guestSide := net.Pipe()
+		go func() {
+			defer guestSide.Close()
+			_ = Serve(context.Background(), guestSide, echo.LocalAddr().String())
+		}()
+		return hostSide, nil
+	}
+	f, err := NewForwarder(logrus.StandardLogger(), "127.0.0.1:0", dial)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	client, err := net.Dial("udp", f.LocalAddr().String())
+	assert.NilError(t, err)
+	defer client.Close()
+	assert.NilError(t, client.SetDeadline(time.Now().Add(10*time.Second)))
+	buf := make([]byte, MaxDatagramSize)
+	for _, s := range []string{"foo", "barbaz", ""} {
+		_, err = client.Write([]byte(s))
+		assert.NilError(t, err)
+		n, err := client.Read(buf)
+		assert.NilError(t, err)
+		assert.Equal(t, s, string(buf[:n]))
+	}
+}